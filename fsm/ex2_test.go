@@ -60,6 +60,7 @@ func Example_ex2() {
 	//     node [shape = circle style=solid];
 	//     { rank = same;
 	//         "Rejected" "Released" }
+	//     "init" -> "ReadyToReview"
 	//     "FixInProgress" -> "ReadyToFix"
 	//     "FixInProgress" -> "ReadyToTest"
 	//     "ReadyToFix" -> "FixInProgress"
@@ -73,7 +74,6 @@ func Example_ex2() {
 	//     "UnderReview" -> "ReadyToFix"
 	//     "UnderReview" -> "ReadyToReview"
 	//     "UnderReview" -> "Rejected"
-	//     "init" -> "ReadyToReview"
 	//     fontsize=22
 	//     label = "
 	// my \"best\" ST graph
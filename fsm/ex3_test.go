@@ -0,0 +1,51 @@
+package fsm_test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nickwells/fsm.mod/fsm"
+)
+
+// Example_ex3 is an example of how an FSM can be drawn with its current
+// position highlighted
+func Example_ex3() {
+	st, err := fsm.NewStateTrans("lifecycle", []fsm.STPair{
+		{fsm.InitState, "start"},
+		{"start", "middle"},
+		{"middle", "finish"},
+	}...)
+	if err != nil {
+		fmt.Println("There was a problem initialising the transitions:", err)
+		return
+	}
+
+	f := fsm.New(st, nil)
+	_ = f.ChangeState("start")
+	_ = f.ChangeState("middle")
+
+	f.PrintDotHighlight(os.Stdout)
+	// Output:
+	// // A state transition graph for
+	// //       lifecycle
+	// digraph st {
+	//     node [shape = doublecircle
+	//           style=filled fillcolor=lightblue];
+	//         "init";
+	//     node [shape = doublecircle
+	//           style=filled fillcolor=grey85];
+	//         "finish";
+	//     node [shape = circle style=solid];
+	//     { rank = same;
+	//         "finish" }
+	//     "middle" [style=filled fillcolor=gold];
+	//     "start" [style=dashed];
+	//     "init" -> "start"
+	//     "middle" -> "finish"
+	//     "start" -> "middle"
+	//     fontsize=22
+	//     label = "
+	// lifecycle
+	// "
+	// }
+}
@@ -1,8 +1,13 @@
 package fsm
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"sort"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"time"
 )
 
 // Underlying is an interface representing a set of functions to be called
@@ -29,24 +34,175 @@ type Underlying interface {
 	SetFSM(f *FSM)
 }
 
+// TransitionChecker is an optional interface which an Underlying can
+// implement if its checks on a transition depend on the state being left as
+// well as the state being entered. If the Underlying satisfies this
+// interface then TransitionAllowedFrom is called in place of
+// TransitionAllowed; the TransitionAllowed method is not called.
+type TransitionChecker interface {
+	// TransitionAllowedFrom is called before the change of state from the
+	// 'from' state to the 'to' state. If it returns an error the
+	// transition is not performed
+	TransitionAllowedFrom(f *FSM, from, to string) error
+}
+
+// RejectionHandler is an optional interface which an Underlying can
+// implement if it needs to know when its own check has forbidden a change
+// of state, for instance to undo any partial work done by the check or to
+// log the rejection.
+type RejectionHandler interface {
+	// TransitionRejected is called immediately after the Underlying's
+	// TransitionAllowed (or TransitionAllowedFrom) method has returned a
+	// non-nil error. It is passed the state that was to be entered and the
+	// error. Note that it is called whenever the check is made, including
+	// by NextStatesAllowed, NextStateStatus and IsStuck.
+	TransitionRejected(f *FSM, to string, err error)
+}
+
+// TerminalOverrider is an optional interface which an Underlying can
+// implement if whether or not a state is final depends on the Underlying's
+// data rather than just on the shape of the state transitions. For
+// instance, a "closed" state might only be final if there are no open
+// sub-tasks.
+type TerminalOverrider interface {
+	// IsTerminalOverride is called by IsInTerminalState with the name of
+	// the current state. If the second value returned is true then the
+	// first value is used as the result of IsInTerminalState, otherwise
+	// the state is terminal only if it has no next states.
+	IsTerminalOverride(f *FSM, state string) (isTerminal, hasOpinion bool)
+}
+
+// Redirector is an optional interface which an Underlying can implement if
+// it needs to change the destination of a requested change of state, for
+// instance so that an approval of a large amount is sent for manual review
+// rather than being approved directly.
+type Redirector interface {
+	// Redirect is called by ChangeState with the requested new state
+	// before any other checks are made. If it returns true then the
+	// returned state is used in place of the requested one and the
+	// redirected change is then checked in the usual way; it must be a
+	// valid next state of the current state or ChangeState will return an
+	// error. If it returns false the requested state is used.
+	Redirect(f *FSM, requested string) (string, bool)
+}
+
+// RevisitHandler is an optional interface which an Underlying can implement
+// if it needs to know when the FSM returns to a state it has been in
+// before, for instance to count the number of times that work has had to
+// be redone. The visits are found from the history of the FSM so the FSM
+// must be created with the WithHistory option.
+type RevisitHandler interface {
+	// OnRevisit is called after a change of state into a state which
+	// already appears in the history of the FSM, just before
+	// OnTransition is called. The visitCount is the number of times the
+	// state has now been visited, so it is always greater than one. Note
+	// that if the history is limited then only the visits still recorded
+	// are counted.
+	OnRevisit(f *FSM, state string, visitCount int)
+}
+
+// Initialiser is an optional interface which an Underlying can implement if
+// it needs to prepare itself, or to check that it is consistent with the
+// StateTrans, when the FSM is created.
+type Initialiser interface {
+	// Init is called by New, and by LoadFSM, once the SetFSM method has
+	// been called (or would have been, if the WithoutSetFSM option is
+	// given) and before any change of state. If it returns an error the
+	// FSM is not created.
+	Init(f *FSM) error
+}
+
+// EventChainer is an optional interface which an Underlying can implement if
+// entering some states should automatically fire a further event (see
+// StateTrans.AddEvent), for instance so that arriving at ReadyToTest
+// starts the tests.
+type EventChainer interface {
+	// NextEvent is called after each change of state, once OnTransition
+	// has returned, unless OnTransition has itself changed the state or
+	// the new state is a pass-through state (see
+	// StateTrans.SetAutoAdvance). If it returns true the returned event is
+	// fired, as if by FireEvent, and NextEvent is then called again after
+	// that change of state, and so on. The chain ends when NextEvent
+	// returns false, when an event cannot be fired or when the limit on
+	// the length of the chain (see WithMaxEventChain) is reached; the
+	// error, if any, is returned by the call of ChangeState that started
+	// the chain. Unlike TransitionAllowed, NextEvent is called when no
+	// change of state is in progress and so it may examine the FSM
+	// freely, but it should not change the state itself.
+	NextEvent(f *FSM) (string, bool)
+}
+
+// DefaultMaxEventChain is the default maximum number of events that can be
+// fired in a chain by an EventChainer. It can be changed with the
+// WithMaxEventChain option.
+const DefaultMaxEventChain = 20
+
+// MaxCascadeDepth is the maximum number of changes of state that can be
+// nested through calls of ChangeState from within the Underlying's
+// OnTransition function.
+const MaxCascadeDepth = 100
+
 // FSM represents a Finite State Machine
 type FSM struct {
-	st      *StateTrans
-	prior   *state
-	current *state
-	und     Underlying
+	st           *StateTrans
+	prior        *state
+	current      *state
+	und          Underlying
+	clock        Clock
+	logger       Logger
+	tracer       Tracer
+	panicHandler func(r any)
+	actor        string
+	ctx          context.Context
+
+	currentName *atomic.Value
+
+	skipSetFSM       bool
+	strictTerminal   bool
+	fireInitialEnter bool
+	lenientFormat    bool
+
+	checking     bool
+	cascadeDepth int
+
+	timeInState map[string]time.Duration
+	enteredAt   time.Time
+
+	history       []Transition
+	keepHistory   bool
+	maxHistoryLen int
+
+	lastMade map[Transition]time.Time
+
+	maxTransitions  int
+	transitionCount int
+
+	maxEventChain int
+	chainDepth    int
 }
 
 // New creates a new Finite State Machine. It returns nil if the StateTrans
-// is nil.
+// is nil or if the Underlying's Init method (see Initialiser) returns an
+// error; NewErr can be used to find out why.
 //
 // The prior and current states are set to InitState.
 //
-// The SetFSM method on the Underlying is called with the new FSM so that the
-// Underlying can store the associated FSM if required.
-func New(st *StateTrans, u Underlying) *FSM {
+// Any options are applied and then the SetFSM method on the Underlying is
+// called with the new FSM so that the Underlying can store the associated
+// FSM if required. The call of SetFSM can be suppressed with the
+// WithoutSetFSM option. If the Underlying is an Initialiser its Init method
+// is then called.
+func New(st *StateTrans, u Underlying, opts ...OptFunc) *FSM {
+	f, _ := NewErr(st, u, opts...)
+	return f
+}
+
+// NewErr creates a new Finite State Machine in the same way as New but
+// returns an error rather than just a nil FSM if the StateTrans is nil or
+// the Underlying's Init method returns an error.
+func NewErr(st *StateTrans, u Underlying, opts ...OptFunc) (*FSM, error) {
 	if st == nil {
-		return nil
+		return nil, errors.New("FSM: the StateTrans is nil")
 	}
 
 	f := &FSM{
@@ -54,11 +210,128 @@ func New(st *StateTrans, u Underlying) *FSM {
 		prior:   st.states[InitState],
 		current: st.states[InitState],
 		und:     u,
+		clock:   realClock{},
+		tracer:  noopTracer{},
+
+		maxEventChain: DefaultMaxEventChain,
+
+		currentName: &atomic.Value{},
 	}
-	if u != nil {
-		u.SetFSM(f)
+	f.currentName.Store(InitState)
+	for _, o := range opts {
+		o(f)
 	}
-	return f
+	if f.timeInState != nil {
+		f.enteredAt = f.clock.Now()
+	}
+	if st.inst != nil {
+		st.inst.add(f)
+	}
+	if u != nil && !f.skipSetFSM {
+		f.callSetFSM()
+	}
+	if err := f.initUnderlying(); err != nil {
+		if st.inst != nil {
+			st.inst.remove(f)
+		}
+		return nil, err
+	}
+	if f.fireInitialEnter {
+		f.onTransition()
+	}
+	return f, nil
+}
+
+// callSetFSM calls the SetFSM method of the Underlying. If the FSM was
+// constructed with the WithRecover option any panic is recovered and
+// passed to the handler.
+func (f *FSM) callSetFSM() {
+	defer f.recoverPanic(nil)
+	f.und.SetFSM(f)
+}
+
+// initUnderlying calls the Init method of the Underlying, if it is an
+// Initialiser, and returns any error. If the FSM was constructed with the
+// WithRecover option any panic is recovered, passed to the handler and
+// reported as an error.
+func (f *FSM) initUnderlying() (err error) {
+	ui, ok := f.und.(Initialiser)
+	if !ok {
+		return nil
+	}
+	defer f.recoverPanic(func(r any) {
+		err = fmt.Errorf("FSM: %q: the Underlying failed to initialise:"+
+			" it panicked: %v", f.st.name, r)
+	})
+
+	if err := ui.Init(f); err != nil {
+		return fmt.Errorf("FSM: %q: the Underlying failed to initialise: %w",
+			f.st.name, err)
+	}
+	return nil
+}
+
+// DetachUnderlying removes the Underlying from the FSM and returns it. Until
+// an Underlying is attached again the FSM behaves as if it had been created
+// with a nil Underlying so changes of state will not call TransitionAllowed
+// or OnTransition. This can be useful, for instance, when replaying a
+// sequence of state changes without triggering their side effects.
+func (f *FSM) DetachUnderlying() Underlying {
+	u := f.und
+	f.und = nil
+	return u
+}
+
+// AttachUnderlying sets the Underlying of the FSM, replacing any existing
+// Underlying. The SetFSM method on the new Underlying is called unless the
+// FSM was created with the WithoutSetFSM option.
+func (f *FSM) AttachUnderlying(u Underlying) {
+	f.und = u
+	if u != nil && !f.skipSetFSM {
+		f.callSetFSM()
+	}
+}
+
+// Close removes the FSM from the tracked instances of its StateTrans (see
+// StateTrans.TrackInstances). It does nothing if the StateTrans is not
+// tracking instances and is safe to call more than once.
+func (f *FSM) Close() {
+	if f.st.inst != nil {
+		f.st.inst.remove(f)
+	}
+}
+
+// SwapStateTrans replaces the StateTrans of the FSM with newSt, for
+// instance after a changed definition of the allowed transitions has been
+// loaded. The current and prior states of the FSM are kept and so must be
+// valid in newSt (see StateTrans.CanRestore); if they are not an error is
+// returned and the FSM is unchanged. If newSt knows the states by an alias
+// or by a name differing only in case the FSM will report them by their
+// names in newSt. If the old StateTrans was tracking instances the FSM is
+// removed from them and if newSt is tracking instances the FSM is added to
+// them.
+func (f *FSM) SwapStateTrans(newSt *StateTrans) error {
+	if newSt == nil {
+		return fmt.Errorf("FSM: %q: the new StateTrans must not be nil",
+			f.st.name)
+	}
+	current, prior, err := newSt.restorePair(f.current.name, f.prior.name)
+	if err != nil {
+		return fmt.Errorf("FSM: %q: cannot change the StateTrans: %w",
+			f.st.name, err)
+	}
+
+	f.Close()
+
+	f.st = newSt
+	f.current = current
+	f.currentName.Store(current.name)
+	f.prior = prior
+
+	if newSt.inst != nil {
+		newSt.inst.add(f)
+	}
+	return nil
 }
 
 // Name returns the name of the Finite State Machine
@@ -66,21 +339,94 @@ func (f *FSM) Name() string {
 	return f.st.name
 }
 
+// Version returns the version of the StateTrans of the FSM (see
+// StateTrans.SetVersion). For an FSM loaded by LoadFSM this is the version
+// recorded when the FSM was saved.
+func (f *FSM) Version() string {
+	return f.st.version
+}
+
 // CurrentState returns the name of the current state of the FSM
 func (f *FSM) CurrentState() string {
 	return f.current.name
 }
 
+// CurrentStateAtomic returns the name of the current state of the FSM. It
+// gives the same value as CurrentState but it may safely be called from
+// another goroutine while the state is being changed. The value is updated
+// once each change of state is complete so a reader will never see a
+// partial change.
+func (f *FSM) CurrentStateAtomic() string {
+	return f.currentName.Load().(string)
+}
+
+// QualifiedStateSep is the separator between the FSM name and the state
+// name in the value returned by QualifiedState
+const QualifiedStateSep = "/"
+
+// QualifiedState returns the name of the FSM and the name of its current
+// state separated by QualifiedStateSep, for instance "bug/UnderReview".
+// This is intended for use as a compact key, for instance in log messages.
+func (f *FSM) QualifiedState() string {
+	return f.st.name + QualifiedStateSep + f.current.name
+}
+
 // PriorState returns the name of the prior state of the FSM
 func (f *FSM) PriorState() string {
 	return f.prior.name
 }
 
-// IsInTerminalState returns true if the FSM is in a terminal state
+// CameVia returns true if the last change of state of the FSM was from the
+// 'from' state to the 'to' state. It returns false if the FSM has not yet
+// changed state. This can be used in the Underlying's OnTransition function
+// to act on the particular transition made rather than just the new state.
+func (f *FSM) CameVia(from, to string) bool {
+	if f.transitionCount == 0 && f.prior == f.current {
+		return false
+	}
+	return f.prior.name == f.st.canonicalName(from) &&
+		f.current.name == f.st.canonicalName(to)
+}
+
+// IsInTerminalState returns true if the FSM is in a terminal state. If the
+// Underlying implements the TerminalOverrider interface and has an opinion
+// about the current state then that takes precedence, otherwise a state is
+// terminal if it has no next states. Note that the WithStrictTerminal
+// option only considers the state transitions and not the Underlying.
 func (f *FSM) IsInTerminalState() bool {
+	if isTerminal, hasOpinion := f.terminalOverride(); hasOpinion {
+		return isTerminal
+	}
 	return f.current.isTerminal()
 }
 
+// terminalOverride returns the opinion of the Underlying, if it is a
+// TerminalOverrider, on whether the current state is terminal. If the FSM
+// was constructed with the WithRecover option any panic is recovered,
+// passed to the handler and treated as having no opinion.
+func (f *FSM) terminalOverride() (isTerminal, hasOpinion bool) {
+	to, ok := f.und.(TerminalOverrider)
+	if !ok {
+		return false, false
+	}
+	defer f.recoverPanic(nil)
+
+	return to.IsTerminalOverride(f, f.current.name)
+}
+
+// Outcome reports whether the FSM is in a terminal state (see
+// IsInTerminalState) and, if the current state has been classified (see
+// StateTrans.ClassifyTerminal), whether that state represents success. If
+// the current state has not been classified then classified and success
+// are both false.
+func (f *FSM) Outcome() (success, isTerminal, classified bool) {
+	isTerminal = f.IsInTerminalState()
+	if !isTerminal || !f.current.classified {
+		return false, isTerminal, false
+	}
+	return f.current.success, true, true
+}
+
 // IsInInitialState returns true if the FSM is in the initial state
 func (f *FSM) IsInInitialState() bool {
 	return f.current.name == InitState
@@ -89,45 +435,789 @@ func (f *FSM) IsInInitialState() bool {
 // NextStates returns a sorted slice containing the names of the valid next
 // states of the FSM
 func (f *FSM) NextStates() []string {
-	states := make([]string, 0, len(f.current.nextState))
-	for _, s := range f.current.nextState {
-		states = append(states, s.name)
+	return f.AppendNextStates(make([]string, 0, len(f.current.nextState)))
+}
+
+// validNext returns the valid next states of the FSM. These are the next
+// states of the current state together with any found by its successor
+// resolver (see StateTrans.SetSuccessorResolver).
+func (f *FSM) validNext() map[string]*state {
+	return f.successors(f.current)
+}
+
+// successors returns the next states of the state together with any found
+// by its successor resolver, which is called with the FSM as it is now.
+func (f *FSM) successors(s *state) map[string]*state {
+	if s.resolver == nil {
+		return s.nextState
+	}
+
+	next := make(map[string]*state, len(s.nextState))
+	for name, ns := range s.nextState {
+		next[name] = ns
+	}
+	for _, name := range s.resolver(f) {
+		if ns, ok := f.st.states[f.st.canonicalName(name)]; ok {
+			next[ns.name] = ns
+		}
+	}
+	return next
+}
+
+// AppendNextStates appends the names of the valid next states of the FSM,
+// in sorted order, to dst and returns the extended slice. If dst has
+// enough spare capacity and the current state has no successor resolver
+// (see SetSuccessorResolver) no memory is allocated so the same slice can
+// be reused across calls, for instance:
+//
+//	buf = f.AppendNextStates(buf[:0])
+//
+// A successor resolver is free to allocate when it is called and the
+// states it finds are merged with the others into a new map, so the call
+// will then allocate even if dst is large enough.
+func (f *FSM) AppendNextStates(dst []string) []string {
+	start := len(dst)
+	for name := range f.validNext() {
+		dst = append(dst, name)
+	}
+
+	// An insertion sort is used as it doesn't allocate and the number of
+	// next states is typically small
+	added := dst[start:]
+	for i := 1; i < len(added); i++ {
+		for j := i; j > 0 && added[j] < added[j-1]; j-- {
+			added[j], added[j-1] = added[j-1], added[j]
+		}
+	}
+
+	return dst
+}
+
+// NextStatesAllowed returns a sorted slice containing the names of those
+// valid next states of the FSM to which a change of state is currently
+// allowed, both by any rules set on the StateTrans, including any rate
+// limits, and by the Underlying. It is empty if the FSM may make no more
+// changes of state (see WithMaxTransitions). Note that the Underlying's
+// TransitionAllowed check is called once for each valid next state so any
+// side effects it has will be repeated.
+func (f *FSM) NextStatesAllowed() []string {
+	states := []string{}
+	validNext := f.validNext()
+	for _, name := range sortedNames(validNext) {
+		if f.checkNext(validNext[name]) == nil {
+			states = append(states, name)
+		}
 	}
-	sort.StringSlice(states).Sort()
 	return states
 }
 
+// NextStateStatus returns a map from the name of each valid next state of
+// the FSM to nil if the change of state is currently allowed or to the
+// TransitionBudgetExceeded, RateLimited or ForbiddenChange error that
+// ChangeState would return if not. Note that the Underlying's
+// TransitionAllowed check is called once for each valid next state so any
+// side effects it has will be repeated.
+func (f *FSM) NextStateStatus() map[string]error {
+	validNext := f.validNext()
+	status := make(map[string]error, len(validNext))
+	for name, next := range validNext {
+		status[name] = f.checkNext(next)
+	}
+	return status
+}
+
+// IsStuck returns true if the FSM is not in a terminal state but every
+// change to a valid next state is forbidden by the Underlying. This can
+// detect a deadlock caused by the Underlying's TransitionAllowed logic which
+// cannot be found by examining the StateTrans alone. Note that, as for
+// NextStatesAllowed, the Underlying's check is called for every valid next
+// state.
+func (f *FSM) IsStuck() bool {
+	return !f.IsInTerminalState() && len(f.NextStatesAllowed()) == 0
+}
+
+// UnreachableFromCurrent returns the sorted names of those states which
+// cannot be reached from the current state of the FSM by any sequence of
+// transitions. The current state is only reported if it cannot be
+// returned to. Note that only the state transitions are considered, the
+// Underlying is not consulted. As for StepsTo, the transitions found by
+// any successor resolvers are followed.
+func (f *FSM) UnreachableFromCurrent() []string {
+	steps := f.stepsFromCurrent()
+
+	unreachable := []string{}
+	for _, name := range sortedNames(f.st.states) {
+		if _, ok := steps[name]; !ok {
+			unreachable = append(unreachable, name)
+		}
+	}
+	return unreachable
+}
+
+// ReachableStateCount returns the number of states which can be reached
+// from the current state of the FSM by some sequence of transitions. As for
+// UnreachableFromCurrent, the current state is only counted if it can be
+// returned to, the Underlying is not consulted and the transitions found
+// by any successor resolvers are followed. It is a cheaper alternative to
+// UnreachableFromCurrent if only the number is needed, for instance to
+// give a measure of progress.
+func (f *FSM) ReachableStateCount() int {
+	return len(f.stepsFromCurrent())
+}
+
+// stepsFromCurrent returns the smallest number of changes of state needed
+// to reach each state which can be reached from the current state of the
+// FSM. The current state is only included if it can be returned to. The
+// successors of each state (see successors) are followed so any successor
+// resolvers are called with the FSM as it is now, not as it would be after
+// the intervening changes of state.
+func (f *FSM) stepsFromCurrent() map[string]int {
+	steps := map[string]int{}
+	queue := []*state{f.current}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		for name, ns := range f.successors(s) {
+			if _, seen := steps[name]; !seen {
+				steps[name] = steps[s.name] + 1
+				queue = append(queue, ns)
+			}
+		}
+	}
+
+	return steps
+}
+
+// StepsTo returns the smallest number of changes of state needed to get
+// from the current state of the FSM to the target state. This is zero if
+// the FSM is already in the target state. Only the state transitions are
+// considered, the Underlying is not consulted. Any successor resolvers
+// (see StateTrans.SetSuccessorResolver) on the way are called with the FSM
+// as it is now, not as it would be after the intervening changes of state.
+// An error is returned if the target state does not exist or cannot be
+// reached from the current state.
+func (f *FSM) StepsTo(target string) (int, error) {
+	target = f.st.canonicalName(target)
+	if !f.st.HasState(target) {
+		return 0, f.mkErrUnknownState(target)
+	}
+	if target == f.current.name {
+		return 0, nil
+	}
+
+	if steps, ok := f.stepsFromCurrent()[target]; ok {
+		return steps, nil
+	}
+
+	return 0, fmt.Errorf("FSM: %q: %q cannot be reached from %q",
+		f.st.name, target, f.current.name)
+}
+
+// WhyNot reports whether a change to the new state would be allowed and,
+// if not, gives a plain-language reason suitable for showing to an end
+// user. The reason is "the limit of <n> changes of state has been
+// reached" if the FSM may make no more changes of state (see
+// WithMaxTransitions), "unknown state" if there is no such state, "no
+// transition from <current state>" if the new state is not a valid next
+// state, "rate limited: <message>" if the change was last made too
+// recently (see StateTrans.SetRateLimit) and "forbidden: <message>" if the
+// change is forbidden by a rule on the StateTrans, by a global guard or by
+// the Underlying. The reason is empty if the change is allowed. The state
+// is not changed but, as for NextStatesAllowed, the Underlying's check is
+// called so any side effects it has will happen.
+func (f *FSM) WhyNot(newState string) (valid bool, reason string) {
+	newState = f.st.canonicalName(newState)
+
+	if f.budgetSpent() {
+		return false, fmt.Sprintf(
+			"the limit of %d changes of state has been reached",
+			f.maxTransitions)
+	}
+	if f.checking {
+		return false, "another transition is being checked"
+	}
+
+	next, ok := f.validNext()[newState]
+	if !ok {
+		if !f.st.HasState(newState) {
+			return false, "unknown state"
+		}
+		return false, "no transition from " + f.current.name
+	}
+
+	if err := f.checkRateLimit(next); err != nil {
+		return false, "rate limited: " + err.Error()
+	}
+	if err := f.checkChange(next); err != nil {
+		return false, "forbidden: " + err.Error()
+	}
+
+	return true, ""
+}
+
+// TransitionsRemaining returns the number of further changes of state that
+// the FSM may make (see WithMaxTransitions). It returns -1 if there is no
+// limit.
+func (f *FSM) TransitionsRemaining() int {
+	if f.maxTransitions < 1 {
+		return -1
+	}
+	return f.maxTransitions - f.transitionCount
+}
+
 // ChangeState changes the state from the current state to the new state
 // provided the new state is a valid transition from the current state of the
 // FSM and the transition is allowed by the Underlying TransitionAllowed
 // function. Following the change of state the Underlying OnTransition function
 // is called
+//
+// ChangeState may be called from within the Underlying's OnTransition
+// function, since the state has already been changed, in order to cascade
+// through several states. To guard against endless cascades no more than
+// MaxCascadeDepth nested changes are allowed. It must not be called from
+// within the Underlying's TransitionAllowed function as the state is
+// about to change. In either case a ReentrantTransition error is returned.
+//
+// If the Underlying implements the Redirector interface it may replace the
+// new state with another; the replacement must then satisfy all the rules
+// above. There is no redirection if the Underlying is nil.
+//
+// If the transition has a rate limit (see StateTrans.SetRateLimit) and it
+// was last made too recently a RateLimited error is returned.
+//
+// If the FSM has already made as many changes of state as it is allowed
+// (see WithMaxTransitions) a TransitionBudgetExceeded error is returned.
+//
+// If the new state is a pass-through state (see StateTrans.SetAutoAdvance)
+// the FSM will then change to its next state in the same way. If that
+// change fails the FSM is left in the pass-through state and the error is
+// returned.
+//
+// If the FSM has a Logger (see WithLogger) it is told of every attempt to
+// change the state, whether it succeeds or not. If the Logger is also a
+// DeprecationLogger it is told when a deprecated transition is made.
 func (f *FSM) ChangeState(newState string) error {
-	oldState := f.current
-	state, ok := f.current.nextState[newState]
+	return f.doChange(newState, false)
+}
+
+// ChangeStateBy changes the state of the FSM exactly as ChangeState does
+// but records the actor (for instance, the user) responsible. The actor can
+// be found through CurrentActor while the change is being made, so that
+// the Underlying's TransitionAllowed function or a guard can check that the
+// actor is authorised, and it is recorded in the history (see WithHistory).
+// Any further changes of state cascading from the Underlying's
+// OnTransition function are also attributed to the actor. Once the change
+// is complete, whether it succeeded or not, the actor is cleared.
+func (f *FSM) ChangeStateBy(actor, newState string) error {
+	prevActor := f.actor
+	f.actor = actor
+	defer func() { f.actor = prevActor }()
+
+	return f.ChangeState(newState)
+}
+
+// CurrentActor returns the actor given to ChangeStateBy while the change of
+// state is being made. It returns an empty string at any other time.
+func (f *FSM) CurrentActor() string {
+	return f.actor
+}
+
+// ChangeStateCtx changes the state of the FSM exactly as ChangeState does
+// but the change is traced by the FSM's Tracer (see WithTracer). The
+// context returned by the Tracer is available through Context while the
+// change is being made so that the Underlying can, for instance, record
+// its own work as part of the trace.
+func (f *FSM) ChangeStateCtx(ctx context.Context, newState string) error {
+	ctx, end := f.tracer.StartTransition(ctx, f.current.name, newState)
+
+	prevCtx := f.ctx
+	f.ctx = ctx
+	defer func() { f.ctx = prevCtx }()
+
+	err := f.ChangeState(newState)
+	end(err)
+
+	return err
+}
+
+// Context returns the context of the change of state being made by
+// ChangeStateCtx. At any other time it returns context.Background().
+func (f *FSM) Context() context.Context {
+	if f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+// Reopen changes the state of the FSM from its current, terminal, state to
+// the new state using a reopen transition (see StateTrans.AddReopen). Only
+// reopen transitions are considered; the normal transitions are not. The
+// same checks are made as for ChangeState, apart from any redirection by
+// the Underlying, and the same errors are returned; in particular a
+// NoTransition error is returned if there is no reopen transition from
+// the current state to the new state.
+func (f *FSM) Reopen(newState string) error {
+	return f.doChange(newState, true)
+}
+
+// doChange changes the state of the FSM, informing the Logger of the
+// attempt and calling the Underlying OnTransition function if it succeeds.
+// If reopen is true only the reopen transitions are used.
+func (f *FSM) doChange(newState string, reopen bool) error {
+	from := f.current.name
+
+	to, err := f.changeState(newState, reopen)
+	if f.logger != nil {
+		f.logger.LogTransition(f.st.name, from, to, err)
+	}
+	if err != nil {
+		return err
+	}
+	f.logDeprecated(from, to)
+	f.onRevisit()
+
+	f.onTransition()
+
+	return f.followOn(to)
+}
+
+// followOn makes any further change of state which should follow the
+// change into the state just entered, provided the Underlying's
+// OnTransition has not already moved the FSM elsewhere. If the state is a
+// pass-through state (see StateTrans.SetAutoAdvance) the FSM is moved on to
+// its next state, otherwise any event requested by the Underlying, if it
+// is an EventChainer, is fired.
+func (f *FSM) followOn(entered string) error {
+	if f.current.name != entered {
+		return nil
+	}
+	if f.current.autoAdvance {
+		return f.doChange(f.current.soleNext().name, false)
+	}
+	return f.chainEvent()
+}
+
+// chainEvent fires the next event given by the Underlying, if it is an
+// EventChainer and it requests one, and returns any error
+func (f *FSM) chainEvent() error {
+	ec, ok := f.und.(EventChainer)
+	if !ok || f.maxEventChain < 1 {
+		return nil
+	}
+	event, ok := f.nextEvent(ec)
+	if !ok {
+		return nil
+	}
+
+	if f.chainDepth >= f.maxEventChain {
+		return fmt.Errorf("FSM: %q: the event %q was not fired from %q:"+
+			" too many chained events (the limit is %d)",
+			f.st.name, event, f.current.name, f.maxEventChain)
+	}
+	f.chainDepth++
+	defer func() { f.chainDepth-- }()
+
+	return f.FireEvent(event)
+}
+
+// nextEvent calls the NextEvent method of the EventChainer and returns the
+// result. If the FSM was constructed with the WithRecover option any panic
+// is recovered, passed to the handler and treated as not requesting an
+// event.
+func (f *FSM) nextEvent(ec EventChainer) (event string, ok bool) {
+	defer f.recoverPanic(nil)
+	return ec.NextEvent(f)
+}
+
+// logDeprecated tells the FSM's Logger, if it is a DeprecationLogger, of
+// the change of state if the transition has been deprecated.
+func (f *FSM) logDeprecated(from, to string) {
+	dl, ok := f.logger.(DeprecationLogger)
+	if !ok {
+		return
+	}
+	if note, ok := f.prior.deprecatedNext[to]; ok {
+		dl.LogDeprecated(f.st.name, from, to, note)
+	}
+}
+
+// changeState performs the checks for ChangeState (or Reopen if reopen is
+// true) and, if they all pass, sets the new state. It returns the name of
+// the state to be changed to, after any redirection, and any error.
+func (f *FSM) changeState(newState string, reopen bool) (string, error) {
+	newState = f.st.canonicalName(newState)
+
+	if f.budgetSpent() {
+		return newState, f.mkErrTransitionBudgetExceeded(newState)
+	}
+	if f.checking {
+		return newState, f.mkErrReentrantTransition(newState,
+			"ChangeState was called while checking another transition")
+	}
+	if f.cascadeDepth >= MaxCascadeDepth {
+		return newState, f.mkErrReentrantTransition(newState,
+			fmt.Sprintf("there are too many nested changes of state"+
+				" (the limit is %d)", MaxCascadeDepth))
+	}
+
+	var next map[string]*state
+	if reopen {
+		next = f.current.reopenTo
+	} else {
+		newState = f.redirect(newState)
+		next = f.validNext()
+	}
+
+	state, ok := next[newState]
 
 	if !ok {
 		if !f.st.HasState(newState) {
-			return f.mkErrUnknownState(newState)
+			return newState, f.mkErrUnknownState(newState)
+		}
+		if !reopen && f.strictTerminal && f.current.isTerminal() {
+			return newState, f.mkErrTerminalReached(newState)
 		}
-		return f.mkErrNoTransition(newState)
+		return newState, f.mkErrNoTransition(newState)
+	}
+
+	if err := f.checkNext(state); err != nil {
+		return newState, err
 	}
 
-	if f.und != nil {
-		if err := f.und.TransitionAllowed(f, newState); err != nil {
-			return f.mkErrForbiddenChange(newState, err)
+	if _, limited := f.current.minInterval[newState]; limited {
+		if f.lastMade == nil {
+			f.lastMade = make(map[Transition]time.Time)
 		}
+		f.lastMade[Transition{From: f.current.name, To: newState}] =
+			f.clock.Now()
 	}
 
-	f.prior = oldState
-	f.current = state
+	f.setState(state)
+	f.transitionCount++
 
-	if f.und != nil {
-		f.und.OnTransition(f)
+	return newState, nil
+}
+
+// redirect returns the state that the FSM should change to in place of the
+// requested state. This is the requested state unless the Underlying
+// implements the Redirector interface and chooses a different state.
+func (f *FSM) redirect(requested string) string {
+	r, ok := f.und.(Redirector)
+	if !ok {
+		return requested
+	}
+
+	prevChecking := f.checking
+	f.checking = true
+	defer func() { f.checking = prevChecking }()
+
+	if target, ok := f.callRedirect(r, requested); ok {
+		return f.st.canonicalName(target)
+	}
+	return requested
+}
+
+// callRedirect calls the Redirect method of the Redirector and returns the
+// result. If the FSM was constructed with the WithRecover option any panic
+// is recovered, passed to the handler and treated as not redirecting.
+func (f *FSM) callRedirect(r Redirector, requested string,
+) (target string, ok bool) {
+	defer f.recoverPanic(nil)
+	return r.Redirect(f, requested)
+}
+
+// onTransition calls the Underlying's OnTransition function, if there is
+// an Underlying, recording the depth of nested calls.
+func (f *FSM) onTransition() {
+	if f.und == nil {
+		return
+	}
+
+	f.cascadeDepth++
+	defer func() { f.cascadeDepth-- }()
+	defer f.recoverPanic(nil)
+
+	f.und.OnTransition(f)
+}
+
+// recoverPanic must be deferred around each call of a method of the
+// Underlying. If the FSM was constructed with the WithRecover option any
+// panic is recovered and passed to the handler and then, if onPanic is not
+// nil, the recovered value is passed to onPanic so that the caller can
+// report it. Otherwise the panic continues.
+func (f *FSM) recoverPanic(onPanic func(r any)) {
+	if f.panicHandler == nil {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	f.panicHandler(r)
+	if onPanic != nil {
+		onPanic(r)
+	}
+}
+
+// checkNext returns the error that ChangeState would return for the change
+// from the current state to the next state, which must be a valid next
+// state, or nil if the change is allowed. This is a
+// TransitionBudgetExceeded error if the FSM may make no more changes of
+// state, a RateLimited error if the change is too soon (see
+// checkRateLimit) or a ForbiddenChange error if it is forbidden (see
+// checkChange).
+func (f *FSM) checkNext(next *state) error {
+	if f.budgetSpent() {
+		return f.mkErrTransitionBudgetExceeded(next.name)
+	}
+	if err := f.checkRateLimit(next); err != nil {
+		return err
+	}
+	if err := f.checkChange(next); err != nil {
+		return f.mkErrForbiddenChange(next.name, err)
 	}
 	return nil
 }
 
+// budgetSpent returns true if the FSM has made as many changes of state as
+// it is allowed (see WithMaxTransitions)
+func (f *FSM) budgetSpent() bool {
+	return f.maxTransitions > 0 && f.transitionCount >= f.maxTransitions
+}
+
+// checkRateLimit returns a RateLimited error if the transition from the
+// current state to the next state has a rate limit (see
+// StateTrans.SetRateLimit) and it was last made too recently.
+func (f *FSM) checkRateLimit(next *state) error {
+	minInterval, limited := f.current.minInterval[next.name]
+	if !limited {
+		return nil
+	}
+	last, ok := f.lastMade[Transition{From: f.current.name, To: next.name}]
+	if !ok {
+		return nil
+	}
+	if elapsed := f.clock.Now().Sub(last); elapsed < minInterval {
+		return f.mkErrRateLimited(next.name, minInterval, elapsed)
+	}
+	return nil
+}
+
+// checkChange returns an error if the change from the current state to the
+// next state is forbidden, either by a rule on the StateTrans, by a global
+// guard, by an entry guard on the next state or by the Underlying. The next
+// state must be a valid next state.
+func (f *FSM) checkChange(next *state) error {
+	if f.current.forbiddenNext[next.name] {
+		return errors.New("the transition has been forbidden")
+	}
+
+	prevChecking := f.checking
+	f.checking = true
+	defer func() { f.checking = prevChecking }()
+
+	if err := f.checkVisited(next); err != nil {
+		return err
+	}
+	for _, g := range f.st.globalGuards {
+		if err := g(f, f.current.name, next.name); err != nil {
+			return err
+		}
+	}
+	for _, g := range next.entryGuards {
+		if err := g(f); err != nil {
+			return err
+		}
+	}
+	return f.transitionAllowed(next.name)
+}
+
+// callTransitionCheck calls the appropriate check method of the Underlying
+// and returns the result. If the FSM was constructed with the WithRecover
+// option any panic in the check is recovered, passed to the handler and
+// reported as an error.
+func (f *FSM) callTransitionCheck(newState string) (err error) {
+	defer f.recoverPanic(func(r any) {
+		err = fmt.Errorf("the check panicked: %v", r)
+	})
+
+	if tc, ok := f.und.(TransitionChecker); ok {
+		return tc.TransitionAllowedFrom(f, f.current.name, newState)
+	}
+	return f.und.TransitionAllowed(f, newState)
+}
+
+// transitionAllowed calls the Underlying's check on the change from the
+// current state to the new state and returns any error. If the Underlying
+// satisfies the TransitionChecker interface its TransitionAllowedFrom method
+// is called, otherwise its TransitionAllowed method is called. If the check
+// fails and the Underlying satisfies the RejectionHandler interface its
+// TransitionRejected method is then called.
+func (f *FSM) transitionAllowed(newState string) error {
+	if f.und == nil {
+		return nil
+	}
+
+	err := f.callTransitionCheck(newState)
+	if err != nil {
+		if rh, ok := f.und.(RejectionHandler); ok {
+			f.transitionRejected(rh, newState, err)
+		}
+	}
+	return err
+}
+
+// transitionRejected calls the TransitionRejected method of the
+// RejectionHandler. If the FSM was constructed with the WithRecover option
+// any panic is recovered and passed to the handler; the change is still
+// refused with the original error.
+func (f *FSM) transitionRejected(rh RejectionHandler, newState string,
+	err error,
+) {
+	defer f.recoverPanic(nil)
+	rh.TransitionRejected(f, newState, err)
+}
+
+// setState records the move from the current state to the new state. If
+// time in state is being tracked the time spent in the state being left is
+// added to its total.
+func (f *FSM) setState(s *state) {
+	if f.timeInState != nil {
+		now := f.clock.Now()
+		f.timeInState[f.current.name] += now.Sub(f.enteredAt)
+		f.enteredAt = now
+	}
+
+	if f.st.inst != nil {
+		f.st.inst.move(f, f.current.name, s.name)
+	}
+
+	f.addHistory(Transition{From: f.current.name, To: s.name, Actor: f.actor})
+
+	f.prior = f.current
+	f.current = s
+	f.currentName.Store(s.name)
+}
+
+// ChangeStateRetry calls ChangeState, retrying up to the given number of
+// attempts in all, while the change is forbidden by the Underlying (the
+// error is a ForbiddenChange). It sleeps for the given delay between
+// attempts using the FSM's Clock. Any other error is permanent and is
+// returned immediately. If every attempt fails the error from the last
+// attempt is returned. At least one attempt is always made.
+//
+// Note that the Underlying OnTransition function is only called once, when
+// the change of state eventually succeeds.
+func (f *FSM) ChangeStateRetry(newState string,
+	attempts int, delay time.Duration,
+) error {
+	var err error
+
+	for i := 0; i == 0 || i < attempts; i++ {
+		if i > 0 {
+			f.clock.Sleep(delay)
+		}
+
+		err = f.ChangeState(newState)
+
+		var fc ForbiddenChange
+		if !errors.As(err, &fc) {
+			return err
+		}
+	}
+	return err
+}
+
+// RunToTerminal repeatedly changes the state of the FSM until it reaches a
+// terminal state. At each step the choose function is passed the current
+// state and the sorted list of next states which are allowed (see
+// NextStatesAllowed) and returns the state to change to; if it returns
+// false the run stops. The run also stops, without an error, if there are
+// no allowed next states; the caller can use IsInTerminalState to tell
+// whether the run completed. If the FSM has made as many changes of state
+// as it is allowed (see WithMaxTransitions) the run stops with a
+// TransitionBudgetExceeded error.
+//
+// To guard against endless loops no more than maxSteps changes of state
+// are made and an error is returned if the FSM is still not in a terminal
+// state after that many steps. A maxSteps value less than 1 means there
+// is no limit. Any error from ChangeState is returned immediately.
+func (f *FSM) RunToTerminal(maxSteps int,
+	choose func(current string, next []string) (string, bool),
+) error {
+	for steps := 0; !f.IsInTerminalState(); steps++ {
+		if maxSteps > 0 && steps >= maxSteps {
+			return fmt.Errorf(
+				"FSM: %q: no terminal state was reached after %d steps",
+				f.st.name, maxSteps)
+		}
+
+		next := f.NextStatesAllowed()
+		if len(next) == 0 {
+			if f.budgetSpent() {
+				return f.mkErrTransitionBudgetExceeded("")
+			}
+			return nil
+		}
+
+		newState, ok := choose(f.current.name, next)
+		if !ok {
+			return nil
+		}
+
+		if err := f.ChangeState(newState); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RandomWalk drives the FSM through a sequence of randomly chosen changes of
+// state and returns the names of the states it passed through, starting
+// with the current state. At each step the new state is chosen, using r,
+// from those which are allowed (see NextStatesAllowed), each being equally
+// likely. The walk stops when the FSM reaches a terminal state, when no
+// change of state is allowed, when a change of state fails or after
+// maxSteps changes of state. A maxSteps value less than 1 means there is
+// no limit. The same source of random numbers, seeded the same way, will
+// give the same walk. This can be used to generate test data.
+func (f *FSM) RandomWalk(r *rand.Rand, maxSteps int) []string {
+	path := []string{f.current.name}
+
+	for steps := 0; maxSteps < 1 || steps < maxSteps; steps++ {
+		if f.IsInTerminalState() {
+			break
+		}
+		next := f.NextStatesAllowed()
+		if len(next) == 0 {
+			break
+		}
+		if err := f.ChangeState(next[r.Intn(len(next))]); err != nil {
+			break
+		}
+		path = append(path, f.current.name)
+	}
+
+	return path
+}
+
+// PrintDotHighlight prints the state transitions of the FSM as a directed
+// graph in the graphviz DOT language in the same way as
+// StateTrans.PrintDot but with the current state of the FSM filled in gold
+// and, if it is different, the prior state drawn with a dashed outline.
+func (f *FSM) PrintDotHighlight(w io.Writer) {
+	highlights := map[string]string{
+		f.current.name: "style=filled fillcolor=gold",
+	}
+	if f.prior != f.current {
+		highlights[f.prior.name] = "style=dashed"
+	}
+
+	f.st.printDot(w, highlights)
+}
+
 // Format is used by the fmt package in the standard library to format the
 // FSM. It supports two formats:
 //
@@ -136,7 +1226,14 @@ func (f *FSM) ChangeState(newState string) error {
 //
 // Either of these can be given the '#' flag which causes them to also print
 // the FSM name and the previous state. Additionally the %s format will print
-// any state descriptions.
+// any state descriptions. The %v format can also be given the '+' flag
+// which, if time in state is being tracked (see WithTimeInState), causes it
+// to also print the time spent in the current state.
+//
+// Any other verb is reported as an error in the same way as for the
+// standard types, for instance "%!d(FSM=start)", unless the FSM was
+// created with the WithLenientFormat option in which case it is treated as
+// %s.
 func (f FSM) Format(fstate fmt.State, c rune) {
 	str := ""
 
@@ -146,6 +1243,10 @@ func (f FSM) Format(fstate fmt.State, c rune) {
 	case 's':
 		str = f.sFormat(fstate)
 	default:
+		if f.lenientFormat {
+			str = f.sFormat(fstate)
+			break
+		}
 		str += "%!" + string(c) +
 			"(FSM=" +
 			f.sFormat(fstate) +
@@ -180,5 +1281,8 @@ func (f FSM) vFormat(fstate fmt.State) string {
 	if fstate.Flag('#') {
 		s += ": PriorState: " + f.prior.name
 	}
+	if fstate.Flag('+') && f.timeInState != nil {
+		s += ": InState: " + f.TimeInCurrentState().String()
+	}
 	return s
 }
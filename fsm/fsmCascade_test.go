@@ -0,0 +1,105 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+// cascadeUnderlying is an Underlying which calls ChangeState from within
+// its callbacks
+type cascadeUnderlying struct {
+	cascadeTo   map[string]string
+	changeInTA  bool
+	queryInTA   bool
+	querying    bool
+	taErr       error
+	cascadeErrs []error
+}
+
+// (u cascadeUnderlying)TransitionAllowed ...
+func (u *cascadeUnderlying) TransitionAllowed(f *fsm.FSM, _ string) error {
+	if u.querying {
+		return nil
+	}
+	if u.queryInTA {
+		u.querying = true
+		_ = f.NextStatesAllowed()
+		u.querying = false
+	}
+	if u.changeInTA {
+		u.taErr = f.ChangeState(fsm.InitState)
+	}
+	return nil
+}
+
+// (u cascadeUnderlying)OnTransition ...
+func (u *cascadeUnderlying) OnTransition(f *fsm.FSM) {
+	if next, ok := u.cascadeTo[f.CurrentState()]; ok {
+		if err := f.ChangeState(next); err != nil {
+			u.cascadeErrs = append(u.cascadeErrs, err)
+		}
+	}
+}
+
+// (u cascadeUnderlying)SetFSM ...
+func (u *cascadeUnderlying) SetFSM(_ *fsm.FSM) {}
+
+func TestCascade(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "A"},
+		fsm.STPair{"B", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	u := &cascadeUnderlying{cascadeTo: map[string]string{"A": "B", "B": "C"}}
+	f := fsm.New(st, u)
+	if err := f.ChangeState("A"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	testhelper.DiffString(t, "cascade", "current state",
+		f.CurrentState(), "C")
+	testhelper.DiffInt(t, "cascade", "cascade errors", len(u.cascadeErrs), 0)
+
+	u = &cascadeUnderlying{cascadeTo: map[string]string{"A": "B", "B": "A"}}
+	f = fsm.New(st, u)
+	if err := f.ChangeState("A"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	testhelper.DiffInt(t, "endless cascade", "cascade errors",
+		len(u.cascadeErrs), 1)
+	var rt fsm.ReentrantTransition
+	if len(u.cascadeErrs) == 1 && !errors.As(u.cascadeErrs[0], &rt) {
+		t.Errorf("endless cascade: expected a ReentrantTransition, got: %v",
+			u.cascadeErrs[0])
+	}
+
+	u = &cascadeUnderlying{changeInTA: true}
+	f = fsm.New(st, u)
+	if err := f.ChangeState("A"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	testhelper.CheckExpErrWithID(t, "change in TransitionAllowed", u.taErr,
+		testhelper.MkExpErr(`The change from "init" to "init"`,
+			"is not allowed",
+			"ChangeState was called while checking another transition"))
+	testhelper.DiffString(t, "change in TransitionAllowed", "current state",
+		f.CurrentState(), "A")
+
+	u = &cascadeUnderlying{changeInTA: true, queryInTA: true}
+	f = fsm.New(st, u)
+	if err := f.ChangeState("A"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	testhelper.CheckExpErrWithID(t, "change after a nested query",
+		u.taErr,
+		testhelper.MkExpErr(
+			"ChangeState was called while checking another transition"))
+	testhelper.DiffString(t, "change after a nested query", "current state",
+		f.CurrentState(), "A")
+}
@@ -1,6 +1,10 @@
 package fsm
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 // Error is the type of an error from this package
 type Error interface {
@@ -11,24 +15,45 @@ type Error interface {
 	FSMError()
 }
 
-// UnknownState is an error type that represents an unknown state
+// UnknownState is an error type that represents an unknown state. Where
+// there are known states with names close to the unknown one (for instance,
+// where the name has been mistyped) they are offered as suggestions. The
+// suggestions are held in an array rather than a slice so that the error
+// can still be compared with ==.
 type UnknownState struct {
 	FSMName string
 	State   string
+
+	suggestions     [maxSuggestions]string
+	suggestionCount int
 }
 
 // mkErrUnknownState constructs and returns an UnknownState error
 func (f FSM) mkErrUnknownState(s string) UnknownState {
-	return UnknownState{
+	fe := UnknownState{
 		FSMName: f.Name(),
 		State:   s,
 	}
+	fe.suggestionCount = copy(fe.suggestions[:], f.st.suggestNames(s))
+	return fe
+}
+
+// Suggestions returns the names of the known states which are closest to
+// the unknown state, the closest first. Only states whose names differ by
+// a few characters are given so it may be empty.
+func (fe UnknownState) Suggestions() []string {
+	return append([]string{}, fe.suggestions[:fe.suggestionCount]...)
 }
 
 // Error returns a string form of the error
 func (fe UnknownState) Error() string {
-	return fmt.Sprintf("FSM: %q: %q is not a known state",
+	msg := fmt.Sprintf("FSM: %q: %q is not a known state",
 		fe.FSMName, fe.State)
+	if fe.suggestionCount > 0 {
+		msg += " (did you mean: " +
+			strings.Join(fe.suggestions[:fe.suggestionCount], ", ") + "?)"
+	}
+	return msg
 }
 
 func (UnknownState) FSMError() {}
@@ -59,10 +84,40 @@ func (fe NoTransition) Error() string {
 
 func (NoTransition) FSMError() {}
 
+// TerminalReached is an error type that represents an attempt to change
+// the state of an FSM which is already in a terminal state. It is only
+// returned if the FSM was created with the WithStrictTerminal option,
+// otherwise a NoTransition error is returned.
+type TerminalReached struct {
+	FSMName   string
+	FromState string
+	ToState   string
+}
+
+// mkErrTerminalReached constructs and returns a TerminalReached error
+func (f FSM) mkErrTerminalReached(s string) TerminalReached {
+	return TerminalReached{
+		FSMName:   f.Name(),
+		FromState: f.current.name,
+		ToState:   s,
+	}
+}
+
+// Error returns a string form of the error
+func (fe TerminalReached) Error() string {
+	return fmt.Sprintf(
+		"FSM: %q: Cannot change from %q to %q: %q is a terminal state",
+		fe.FSMName, fe.FromState, fe.ToState, fe.FromState)
+}
+
+func (TerminalReached) FSMError() {}
+
 // ForbiddenChange is an error type that represents a forbidden transition
 // between states. This is the case where the fsm would allow the transition
 // but the underlying check prevents it (TransitionAllowed returns a non-nil
-// error)
+// error) or some additional rule set on the StateTrans, such as
+// RequireVisited, prevents it. In that case the UndError describes the
+// rule that was broken.
 type ForbiddenChange struct {
 	FSMName   string
 	FromState string
@@ -92,3 +147,102 @@ func (fe ForbiddenChange) Error() string {
 }
 
 func (ForbiddenChange) FSMError() {}
+
+// ReentrantTransition is an error type that represents a change of state
+// which was attempted from within the Underlying's check of another
+// transition or which was nested too deeply within calls of OnTransition.
+type ReentrantTransition struct {
+	FSMName   string
+	FromState string
+	ToState   string
+	Reason    string
+}
+
+// mkErrReentrantTransition constructs and returns a ReentrantTransition
+// error
+func (f FSM) mkErrReentrantTransition(s, reason string) ReentrantTransition {
+	return ReentrantTransition{
+		FSMName:   f.Name(),
+		FromState: f.current.name,
+		ToState:   s,
+		Reason:    reason,
+	}
+}
+
+// Error returns a string form of the error
+func (fe ReentrantTransition) Error() string {
+	return fmt.Sprintf("FSM: %q: The change from %q to %q is not allowed: %s",
+		fe.FSMName, fe.FromState, fe.ToState, fe.Reason)
+}
+
+func (ReentrantTransition) FSMError() {}
+
+// RateLimited is an error type that represents a change of state which was
+// attempted too soon after the same transition was last made. This is only
+// returned for transitions which have been given a minimum interval (see
+// StateTrans.SetRateLimit).
+type RateLimited struct {
+	FSMName     string
+	FromState   string
+	ToState     string
+	MinInterval time.Duration
+	Elapsed     time.Duration
+}
+
+// mkErrRateLimited constructs and returns a RateLimited error
+func (f FSM) mkErrRateLimited(s string, minInterval, elapsed time.Duration,
+) RateLimited {
+	return RateLimited{
+		FSMName:     f.Name(),
+		FromState:   f.current.name,
+		ToState:     s,
+		MinInterval: minInterval,
+		Elapsed:     elapsed,
+	}
+}
+
+// Error returns a string form of the error
+func (fe RateLimited) Error() string {
+	return fmt.Sprintf("FSM: %q: The change from %q to %q is too soon:"+
+		" it was last made %s ago, the minimum interval is %s",
+		fe.FSMName, fe.FromState, fe.ToState, fe.Elapsed, fe.MinInterval)
+}
+
+func (RateLimited) FSMError() {}
+
+// TransitionBudgetExceeded is an error type that represents an attempt to
+// change the state of an FSM which has already made as many changes of
+// state as it is allowed (see WithMaxTransitions). The ToState is empty if
+// no particular state was being changed to, as when RunToTerminal stops.
+type TransitionBudgetExceeded struct {
+	FSMName        string
+	FromState      string
+	ToState        string
+	MaxTransitions int
+}
+
+// mkErrTransitionBudgetExceeded constructs and returns a
+// TransitionBudgetExceeded error
+func (f FSM) mkErrTransitionBudgetExceeded(s string,
+) TransitionBudgetExceeded {
+	return TransitionBudgetExceeded{
+		FSMName:        f.Name(),
+		FromState:      f.current.name,
+		ToState:        s,
+		MaxTransitions: f.maxTransitions,
+	}
+}
+
+// Error returns a string form of the error
+func (fe TransitionBudgetExceeded) Error() string {
+	if fe.ToState == "" {
+		return fmt.Sprintf("FSM: %q: Cannot change from %q:"+
+			" the limit of %d changes of state has been reached",
+			fe.FSMName, fe.FromState, fe.MaxTransitions)
+	}
+	return fmt.Sprintf("FSM: %q: Cannot change from %q to %q:"+
+		" the limit of %d changes of state has been reached",
+		fe.FSMName, fe.FromState, fe.ToState, fe.MaxTransitions)
+}
+
+func (TransitionBudgetExceeded) FSMError() {}
@@ -0,0 +1,55 @@
+package fsm
+
+import "fmt"
+
+// eventTarget returns the state that the event leads to from the given
+// state or an error if the event cannot be fired from that state.
+func (f *FSM) eventTarget(s *state, event string) (*state, error) {
+	target, ok := s.events[event]
+	if !ok {
+		return nil, fmt.Errorf("FSM: %q: the event %q cannot be fired from %q",
+			f.st.name, event, s.name)
+	}
+	return s.nextState[target], nil
+}
+
+// FireEvent changes the state of the FSM along the transition given the
+// event name from the current state (see StateTrans.AddEvent). It returns
+// an error if the event cannot be fired from the current state; otherwise
+// it behaves exactly as ChangeState for the state the event leads to.
+func (f *FSM) FireEvent(event string) error {
+	next, err := f.eventTarget(f.current, event)
+	if err != nil {
+		return err
+	}
+	return f.ChangeState(next.name)
+}
+
+// AvailableEvents returns the sorted names of the events which can be fired
+// from the current state of the FSM. Note that firing an event may still
+// fail if the change of state it leads to is refused.
+func (f *FSM) AvailableEvents() []string {
+	return f.current.eventNames()
+}
+
+// TraceEvents returns the states that the FSM would pass through if each of
+// the events were fired in turn, starting from the current state. The FSM
+// itself is not changed and only the events and transitions of the
+// StateTrans are considered; the Underlying, any guards and any other
+// rules are not consulted. If an event cannot be fired from the state
+// reached by that point the states reached so far are returned together
+// with the error.
+func (f *FSM) TraceEvents(events ...string) ([]string, error) {
+	trace := make([]string, 0, len(events))
+
+	s := f.current
+	for _, event := range events {
+		next, err := f.eventTarget(s, event)
+		if err != nil {
+			return trace, err
+		}
+		s = next
+		trace = append(trace, s.name)
+	}
+	return trace, nil
+}
@@ -0,0 +1,223 @@
+package fsm_test
+
+import (
+	"testing"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+// mkEventST returns a StateTrans with named events for use in the tests
+func mkEventST(t *testing.T) *fsm.StateTrans {
+	t.Helper()
+
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "review"},
+		fsm.STPair{"review", "fix"},
+		fsm.STPair{"review", "done"},
+		fsm.STPair{"fix", "review"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	for _, e := range []struct{ event, from, to string }{
+		{"submit", fsm.InitState, "review"},
+		{"reject", "review", "fix"},
+		{"approve", "review", "done"},
+		{"submit", "fix", "review"},
+	} {
+		if err := st.AddEvent(e.event, e.from, e.to); err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+	}
+	return st
+}
+
+func TestAddEvent(t *testing.T) {
+	st := mkEventST(t)
+
+	err := st.AddEvent("approve", "review", "fix")
+	testhelper.CheckExpErrWithID(t, "duplicate", err,
+		testhelper.MkExpErr(
+			`testFSM: event: "approve" already leads from "review" to "done"`))
+	err = st.AddEvent("skip", fsm.InitState, "done")
+	testhelper.CheckExpErrWithID(t, "no transition", err,
+		testhelper.MkExpErr(
+			`testFSM: there is no transition from "init" to "done"`))
+	err = st.AddEvent("skip", "nonesuch", "done")
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`))
+}
+
+func TestFireEvent(t *testing.T) {
+	f := fsm.New(mkEventST(t), nil)
+
+	for _, event := range []string{"submit", "reject", "submit"} {
+		if err := f.FireEvent(event); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	testhelper.DiffString(t, "fired", "current state",
+		f.CurrentState(), "review")
+
+	err := f.FireEvent("submit")
+	testhelper.CheckExpErrWithID(t, "bad event", err,
+		testhelper.MkExpErr(
+			`FSM: "testFSM": the event "submit" cannot be fired from "review"`))
+}
+
+func TestTraceEvents(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		events   []string
+		expTrace []string
+	}{
+		{
+			ID:       testhelper.MkID("no events"),
+			expTrace: []string{},
+		},
+		{
+			ID:       testhelper.MkID("rework then approval"),
+			events:   []string{"submit", "reject", "submit", "approve"},
+			expTrace: []string{"review", "fix", "review", "done"},
+		},
+		{
+			ID:       testhelper.MkID("bad event"),
+			events:   []string{"submit", "approve", "reject"},
+			expTrace: []string{"review", "done"},
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": the event "reject" cannot be fired from "done"`),
+		},
+	}
+
+	f := fsm.New(mkEventST(t), nil)
+	for _, tc := range testCases {
+		trace, err := f.TraceEvents(tc.events...)
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffStringSlice(t, tc.IDStr(), "trace", trace, tc.expTrace)
+		testhelper.DiffString(t, tc.IDStr(), "current state",
+			f.CurrentState(), fsm.InitState)
+	}
+}
+
+func TestEventsFrom(t *testing.T) {
+	st := mkEventST(t)
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		state     string
+		expEvents []string
+	}{
+		{
+			ID:        testhelper.MkID("one event"),
+			state:     fsm.InitState,
+			expEvents: []string{"submit"},
+		},
+		{
+			ID:        testhelper.MkID("several events"),
+			state:     "review",
+			expEvents: []string{"approve", "reject"},
+		},
+		{
+			ID:        testhelper.MkID("no events"),
+			state:     "done",
+			expEvents: []string{},
+		},
+		{
+			ID:     testhelper.MkID("unknown state"),
+			state:  "nonesuch",
+			ExpErr: testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`),
+		},
+	}
+
+	for _, tc := range testCases {
+		events, err := st.EventsFrom(tc.state)
+		if testhelper.CheckExpErr(t, err, tc) && err == nil {
+			testhelper.DiffStringSlice(t, tc.IDStr(), "events",
+				events, tc.expEvents)
+		}
+	}
+
+	f := fsm.New(st, nil)
+	if err := f.FireEvent("submit"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	testhelper.DiffStringSlice(t, "available", "events",
+		f.AvailableEvents(), []string{"approve", "reject"})
+}
+
+// chainingUnderlying is an Underlying which fires the event given for the
+// current state
+type chainingUnderlying struct {
+	next map[string]string
+}
+
+// (u chainingUnderlying)TransitionAllowed ...
+func (u chainingUnderlying) TransitionAllowed(_ *fsm.FSM, _ string) error {
+	return nil
+}
+
+// (u chainingUnderlying)OnTransition ...
+func (u chainingUnderlying) OnTransition(_ *fsm.FSM) {}
+
+// (u chainingUnderlying)SetFSM ...
+func (u chainingUnderlying) SetFSM(_ *fsm.FSM) {}
+
+// (u chainingUnderlying)NextEvent ...
+func (u chainingUnderlying) NextEvent(f *fsm.FSM) (string, bool) {
+	event, ok := u.next[f.CurrentState()]
+	return event, ok
+}
+
+func TestNextEvent(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		next     map[string]string
+		opts     []fsm.OptFunc
+		expState string
+	}{
+		{
+			ID:       testhelper.MkID("no chaining"),
+			expState: "review",
+		},
+		{
+			ID:       testhelper.MkID("chained to the end"),
+			next:     map[string]string{"review": "approve"},
+			expState: "done",
+		},
+		{
+			ID:       testhelper.MkID("chaining turned off"),
+			next:     map[string]string{"review": "approve"},
+			opts:     []fsm.OptFunc{fsm.WithMaxEventChain(0)},
+			expState: "review",
+		},
+		{
+			ID:       testhelper.MkID("bad event"),
+			next:     map[string]string{"review": "submit"},
+			expState: "review",
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": the event "submit" cannot be fired from "review"`),
+		},
+		{
+			ID:       testhelper.MkID("endless chain"),
+			next:     map[string]string{"review": "reject", "fix": "submit"},
+			opts:     []fsm.OptFunc{fsm.WithMaxEventChain(3)},
+			expState: "fix",
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": the event "submit" was not fired from "fix":` +
+					" too many chained events (the limit is 3)"),
+		},
+	}
+
+	st := mkEventST(t)
+	for _, tc := range testCases {
+		f := fsm.New(st, chainingUnderlying{next: tc.next}, tc.opts...)
+		err := f.ChangeState("review")
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffString(t, tc.IDStr(), "current state",
+			f.CurrentState(), tc.expState)
+	}
+}
@@ -3,6 +3,7 @@ package fsm_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/nickwells/fsm.mod/fsm"
 	"github.com/nickwells/testhelper.mod/v2/testhelper"
@@ -61,3 +62,87 @@ func TestFormat(t *testing.T) {
 			out, tc.expOut)
 	}
 }
+
+func TestLenientFormat(t *testing.T) {
+	st, _ := fsm.NewStateTrans("lifecycle",
+		fsm.STPair{fsm.InitState, "start"})
+	_ = st.SetStateDesc("start", "the first state")
+	f := fsm.New(st, nil, fsm.WithLenientFormat())
+	_ = f.ChangeState("start")
+
+	testCases := []struct {
+		testhelper.ID
+		formatStr string
+		expOut    string
+	}{
+		{
+			ID:        testhelper.MkID("unsupported format"),
+			formatStr: "%d",
+			expOut:    "start",
+		},
+		{
+			ID:        testhelper.MkID("unsupported format - expanded"),
+			formatStr: "%#q",
+			expOut: "lifecycle: start [the first state]" +
+				" (was: init [the initial state])",
+		},
+		{
+			ID:        testhelper.MkID("supported format"),
+			formatStr: "%v",
+			expOut:    "State: start",
+		},
+	}
+
+	for _, tc := range testCases {
+		out := fmt.Sprintf(tc.formatStr, f)
+		testhelper.DiffString(t,
+			tc.IDStr()+": format string: "+tc.formatStr, "output",
+			out, tc.expOut)
+	}
+}
+
+func TestFormatTimeInState(t *testing.T) {
+	st, _ := fsm.NewStateTrans("lifecycle",
+		fsm.STPair{fsm.InitState, "start"})
+	c := &fakeClock{now: time.Now()}
+	f := fsm.New(st, nil, fsm.WithTimeInState(), fsm.WithClock(c))
+	_ = f.ChangeState("start")
+	c.Sleep(90 * time.Second)
+
+	untracked := fsm.New(st, nil)
+	_ = untracked.ChangeState("start")
+
+	testCases := []struct {
+		testhelper.ID
+		f         *fsm.FSM
+		formatStr string
+		expOut    string
+	}{
+		{
+			ID:        testhelper.MkID("'+' flag"),
+			f:         f,
+			formatStr: "%+v",
+			expOut:    "State: start: InState: 1m30s",
+		},
+		{
+			ID:        testhelper.MkID("'+' and '#' flags"),
+			f:         f,
+			formatStr: "%+#v",
+			expOut: "FSMType: lifecycle: State: start: PriorState: init" +
+				": InState: 1m30s",
+		},
+		{
+			ID:        testhelper.MkID("'+' flag - untracked"),
+			f:         untracked,
+			formatStr: "%+#v",
+			expOut:    "FSMType: lifecycle: State: start: PriorState: init",
+		},
+	}
+
+	for _, tc := range testCases {
+		out := fmt.Sprintf(tc.formatStr, tc.f)
+		testhelper.DiffString(t,
+			tc.IDStr()+": format string: "+tc.formatStr, "output",
+			out, tc.expOut)
+	}
+}
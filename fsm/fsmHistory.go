@@ -0,0 +1,165 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transition records a change of state of an FSM. The Actor is the one
+// given to ChangeStateBy and is empty if the change was made by some other
+// means.
+type Transition struct {
+	From  string
+	To    string
+	Actor string
+}
+
+// addHistory records the transition in the history if history is being
+// kept, discarding the oldest transition if the history is full.
+func (f *FSM) addHistory(t Transition) {
+	if !f.keepHistory {
+		return
+	}
+
+	f.history = append(f.history, t)
+	if f.maxHistoryLen > 0 && len(f.history) > f.maxHistoryLen {
+		f.history = f.history[len(f.history)-f.maxHistoryLen:]
+	}
+}
+
+// History returns a copy of the recorded changes of state of the FSM,
+// oldest first. It returns nil if the history is not being kept (see
+// WithHistory).
+func (f *FSM) History() []Transition {
+	if !f.keepHistory {
+		return nil
+	}
+
+	return append([]Transition{}, f.history...)
+}
+
+// Breadcrumb returns the names of the most recent states of the FSM, oldest
+// first and ending with the current state, joined by sep; for instance,
+// with a sep of " > ":
+//
+//	init > ReadyToReview > UnderReview
+//
+// The states are found from the history of the FSM (see WithHistory). If
+// the history is not being kept only the prior and current states are
+// given, or just the current state if they are the same. No more than
+// maxDepth states are given; a maxDepth less than 1 means there is no
+// limit.
+func (f *FSM) Breadcrumb(maxDepth int, sep string) string {
+	var crumbs []string
+	switch {
+	case f.keepHistory && len(f.history) > 0:
+		crumbs = append(crumbs, f.history[0].From)
+		for _, t := range f.history {
+			crumbs = append(crumbs, t.To)
+		}
+	case f.keepHistory || f.prior == f.current:
+		crumbs = []string{f.current.name}
+	default:
+		crumbs = []string{f.prior.name, f.current.name}
+	}
+
+	if maxDepth > 0 && len(crumbs) > maxDepth {
+		crumbs = crumbs[len(crumbs)-maxDepth:]
+	}
+	return strings.Join(crumbs, sep)
+}
+
+// VisitCounts returns a map from the name of each state that the FSM has
+// been in to the number of times it has been in that state, including the
+// state it started in. The counts are found from the history of the FSM so
+// if the history is limited (see WithHistory) only the visits still
+// recorded are counted. It returns nil if the history is not being kept.
+func (f *FSM) VisitCounts() map[string]int {
+	if !f.keepHistory {
+		return nil
+	}
+
+	counts := map[string]int{}
+	if len(f.history) == 0 {
+		counts[f.current.name] = 1
+		return counts
+	}
+
+	counts[f.history[0].From]++
+	for _, t := range f.history {
+		counts[t.To]++
+	}
+	return counts
+}
+
+// hasVisited returns true if the FSM is known to have been in the named
+// state. This is always true for the InitState, otherwise the state must be
+// present in the history. Note that if the history is not being kept or if
+// older transitions have been discarded this may return false even though
+// the state was visited.
+func (f *FSM) hasVisited(name string) bool {
+	if name == InitState {
+		return true
+	}
+
+	for i, t := range f.history {
+		if t.To == name || (i == 0 && t.From == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// visitCount returns the number of times that the FSM is known to have been
+// in the named state according to its history.
+func (f *FSM) visitCount(name string) int {
+	return f.VisitCounts()[name]
+}
+
+// onRevisit calls the Underlying's OnRevisit function, if the Underlying
+// is a RevisitHandler and history is being kept, when the current state
+// has been visited before. If the FSM was constructed with the WithRecover
+// option any panic is recovered and passed to the handler.
+func (f *FSM) onRevisit() {
+	rh, ok := f.und.(RevisitHandler)
+	if !ok || !f.keepHistory {
+		return
+	}
+	defer f.recoverPanic(nil)
+
+	if count := f.visitCount(f.current.name); count > 1 {
+		rh.OnRevisit(f, f.current.name, count)
+	}
+}
+
+// checkVisited returns an error if any of the states which must have been
+// visited before the given state can be entered are not present in the
+// history.
+func (f *FSM) checkVisited(s *state) error {
+	for _, name := range s.mustHaveVisited {
+		if !f.hasVisited(name) {
+			return fmt.Errorf("the state %q must be visited before %q",
+				name, s.name)
+		}
+	}
+	return nil
+}
+
+// Replay applies each of the transitions in turn, changing the state of the
+// FSM to the To state of the transition with ChangeState. It stops at the
+// first change of state that fails and returns the index of that transition
+// and the error. If every change succeeds it returns the number of
+// transitions and a nil error.
+//
+// This can be used with a newly created FSM to check whether a recorded
+// lifecycle is still valid, for instance after the StateTrans has been
+// changed. Note that the Underlying's functions are called as normal; use
+// DetachUnderlying if this is not wanted.
+func (f *FSM) Replay(history []Transition) (int, error) {
+	for i, t := range history {
+		if err := f.ChangeState(t.To); err != nil {
+			return i, err
+		}
+	}
+	return len(history), nil
+}
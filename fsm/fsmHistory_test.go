@@ -0,0 +1,401 @@
+package fsm_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestReplay(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "A"},
+		fsm.STPair{"B", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		history  []fsm.Transition
+		expIdx   int
+		expState string
+	}{
+		{
+			ID: testhelper.MkID("good"),
+			history: []fsm.Transition{
+				{From: fsm.InitState, To: "A"},
+				{From: "A", To: "B"},
+				{From: "B", To: "A"},
+				{From: "A", To: "B"},
+				{From: "B", To: "C"},
+			},
+			expIdx:   5,
+			expState: "C",
+		},
+		{
+			ID:       testhelper.MkID("good - empty"),
+			expState: fsm.InitState,
+		},
+		{
+			ID: testhelper.MkID("bad - no transition"),
+			history: []fsm.Transition{
+				{From: fsm.InitState, To: "A"},
+				{From: "A", To: "C"},
+				{From: "C", To: "B"},
+			},
+			expIdx:   1,
+			expState: "A",
+			ExpErr: testhelper.MkExpErr(
+				`There is no valid transition from "A" to "C"`),
+		},
+		{
+			ID: testhelper.MkID("bad - unknown state"),
+			history: []fsm.Transition{
+				{From: fsm.InitState, To: "X"},
+			},
+			expState: fsm.InitState,
+			ExpErr:   testhelper.MkExpErr(`"X" is not a known state`),
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil)
+		idx, err := f.Replay(tc.history)
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffInt(t, tc.IDStr(), "index", idx, tc.expIdx)
+		testhelper.DiffString(t, tc.IDStr(), "current state",
+			f.CurrentState(), tc.expState)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		opts       []fsm.OptFunc
+		expHistory []fsm.Transition
+	}{
+		{
+			ID: testhelper.MkID("no history"),
+		},
+		{
+			ID:   testhelper.MkID("unlimited history"),
+			opts: []fsm.OptFunc{fsm.WithHistory(0)},
+			expHistory: []fsm.Transition{
+				{From: fsm.InitState, To: "A"},
+				{From: "A", To: "B"},
+				{From: "B", To: "A"},
+			},
+		},
+		{
+			ID:   testhelper.MkID("limited history"),
+			opts: []fsm.OptFunc{fsm.WithHistory(2)},
+			expHistory: []fsm.Transition{
+				{From: "A", To: "B"},
+				{From: "B", To: "A"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil, tc.opts...)
+		_ = f.ChangeState("A")
+		_ = f.ChangeState("B")
+		_ = f.ChangeState("nonesuch")
+		_ = f.ChangeState("A")
+		if err := testhelper.DiffVals(f.History(), tc.expHistory); err != nil {
+			t.Log(tc.IDStr())
+			t.Errorf("\t: history: %s", err)
+		}
+	}
+}
+
+func TestRequireVisited(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "Fixed"},
+		fsm.STPair{"Fixed", "Tested"},
+		fsm.STPair{"Tested", "Fixed"},
+		fsm.STPair{"Fixed", "Released"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	err = st.RequireVisited("Released", "nonesuch")
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`))
+
+	if err = st.RequireVisited("Released", "Tested", fsm.InitState); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var u underlying
+	u.allowChange = true
+	f := fsm.New(st, &u, fsm.WithHistory(0))
+	_ = f.ChangeState("Fixed")
+	u.Reset()
+	u.allowChange = true
+	err = f.ChangeState("Released")
+	testhelper.CheckExpErrWithID(t, "not visited", err,
+		testhelper.MkExpErr("is forbidden",
+			`the state "Tested" must be visited before "Released"`))
+	testhelper.DiffBool(t, "not visited", "TransitionAllowed called",
+		u.transitionAllowedCalled, false)
+
+	_ = f.ChangeState("Tested")
+	_ = f.ChangeState("Fixed")
+	if err = f.ChangeState("Released"); err != nil {
+		t.Error("visited: unexpected error:", err)
+	}
+
+	noHist := fsm.New(st, nil)
+	_ = noHist.ChangeState("Fixed")
+	_ = noHist.ChangeState("Tested")
+	_ = noHist.ChangeState("Fixed")
+	err = noHist.ChangeState("Released")
+	testhelper.CheckExpErrWithID(t, "no history", err,
+		testhelper.MkExpErr("is forbidden",
+			`the state "Tested" must be visited before "Released"`))
+}
+
+type revisitUnderlying struct {
+	underlying
+	revisits []string
+}
+
+// (u revisitUnderlying)OnRevisit ...
+func (u *revisitUnderlying) OnRevisit(_ *fsm.FSM, state string,
+	visitCount int,
+) {
+	u.revisits = append(u.revisits, fmt.Sprintf("%s: %d", state, visitCount))
+}
+
+func TestOnRevisit(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "fix"},
+		fsm.STPair{"fix", "test"},
+		fsm.STPair{"test", "fix"},
+		fsm.STPair{"test", fsm.InitState})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	path := []string{"fix", "test", "fix", "test", "fix", "test", "init"}
+
+	testCases := []struct {
+		testhelper.ID
+		opts   []fsm.OptFunc
+		expect []string
+	}{
+		{
+			ID:     testhelper.MkID("no history"),
+			expect: []string{},
+		},
+		{
+			ID:   testhelper.MkID("full history"),
+			opts: []fsm.OptFunc{fsm.WithHistory(0)},
+			expect: []string{
+				"fix: 2", "test: 2", "fix: 3", "test: 3", "init: 2",
+			},
+		},
+		{
+			ID:     testhelper.MkID("limited history"),
+			opts:   []fsm.OptFunc{fsm.WithHistory(2)},
+			expect: []string{"fix: 2", "test: 2", "fix: 2", "test: 2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		u := revisitUnderlying{revisits: []string{}}
+		u.allowChange = true
+		f := fsm.New(st, &u, tc.opts...)
+		for _, s := range path {
+			if err := f.ChangeState(s); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		testhelper.DiffStringSlice(t, tc.IDStr(), "revisits",
+			u.revisits, tc.expect)
+	}
+}
+
+func TestVisitCounts(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "fix"},
+		fsm.STPair{"fix", "test"},
+		fsm.STPair{"test", "fix"},
+		fsm.STPair{"test", "done"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	path := []string{"fix", "test", "fix", "test", "fix", "test", "done"}
+
+	testCases := []struct {
+		testhelper.ID
+		opts   []fsm.OptFunc
+		steps  int
+		expect map[string]int
+	}{
+		{
+			ID: testhelper.MkID("no history"),
+		},
+		{
+			ID:     testhelper.MkID("no changes"),
+			opts:   []fsm.OptFunc{fsm.WithHistory(0)},
+			expect: map[string]int{fsm.InitState: 1},
+		},
+		{
+			ID:    testhelper.MkID("full history"),
+			opts:  []fsm.OptFunc{fsm.WithHistory(0)},
+			steps: len(path),
+			expect: map[string]int{
+				fsm.InitState: 1,
+				"fix":         3,
+				"test":        3,
+				"done":        1,
+			},
+		},
+		{
+			ID:    testhelper.MkID("limited history"),
+			opts:  []fsm.OptFunc{fsm.WithHistory(3)},
+			steps: len(path),
+			expect: map[string]int{
+				"fix":  1,
+				"test": 2,
+				"done": 1,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil, tc.opts...)
+		for _, s := range path[:tc.steps] {
+			if err := f.ChangeState(s); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		if err := testhelper.DiffVals(f.VisitCounts(), tc.expect); err != nil {
+			t.Log(tc.IDStr())
+			t.Errorf("\t: visit counts: %s", err)
+		}
+	}
+}
+
+func TestChangeStateBy(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "open"},
+		fsm.STPair{"open", "closed"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.AddEntryGuard("closed", func(f *fsm.FSM) error {
+		if f.CurrentActor() != "admin" {
+			return fmt.Errorf("%q may not close", f.CurrentActor())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	f := fsm.New(st, nil, fsm.WithHistory(0))
+	if err = f.ChangeStateBy("alice", "open"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	err = f.ChangeStateBy("bob", "closed")
+	testhelper.CheckExpErrWithID(t, "not authorised", err,
+		testhelper.MkExpErr(`"bob" may not close`))
+	testhelper.DiffString(t, "after failure", "actor", f.CurrentActor(), "")
+	err = f.ChangeState("closed")
+	testhelper.CheckExpErrWithID(t, "no actor", err,
+		testhelper.MkExpErr(`"" may not close`))
+	if err = f.ChangeStateBy("admin", "closed"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	testhelper.DiffString(t, "after success", "actor", f.CurrentActor(), "")
+
+	testhelper.DiffSlice(t, "actors", "history", f.History(),
+		[]fsm.Transition{
+			{From: fsm.InitState, To: "open", Actor: "alice"},
+			{From: "open", To: "closed", Actor: "admin"},
+		})
+}
+
+func TestBreadcrumb(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		opts     []fsm.OptFunc
+		changes  []string
+		maxDepth int
+		exp      string
+	}{
+		{
+			ID:   testhelper.MkID("history - no changes"),
+			opts: []fsm.OptFunc{fsm.WithHistory(0)},
+			exp:  "init",
+		},
+		{
+			ID:      testhelper.MkID("history - all"),
+			opts:    []fsm.OptFunc{fsm.WithHistory(0)},
+			changes: []string{"A", "B", "C"},
+			exp:     "init > A > B > C",
+		},
+		{
+			ID:       testhelper.MkID("history - limited depth"),
+			opts:     []fsm.OptFunc{fsm.WithHistory(0)},
+			changes:  []string{"A", "B", "C"},
+			maxDepth: 2,
+			exp:      "B > C",
+		},
+		{
+			ID:      testhelper.MkID("history - limited length"),
+			opts:    []fsm.OptFunc{fsm.WithHistory(1)},
+			changes: []string{"A", "B", "C"},
+			exp:     "B > C",
+		},
+		{
+			ID:  testhelper.MkID("no history - no changes"),
+			exp: "init",
+		},
+		{
+			ID:      testhelper.MkID("no history"),
+			changes: []string{"A", "B", "C"},
+			exp:     "B > C",
+		},
+		{
+			ID:       testhelper.MkID("no history - limited depth"),
+			changes:  []string{"A", "B", "C"},
+			maxDepth: 1,
+			exp:      "C",
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil, tc.opts...)
+		for _, s := range tc.changes {
+			if err := f.ChangeState(s); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		testhelper.DiffString(t, tc.IDStr(), "breadcrumb",
+			f.Breadcrumb(tc.maxDepth, " > "), tc.exp)
+	}
+}
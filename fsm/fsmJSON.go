@@ -0,0 +1,73 @@
+package fsm
+
+import "encoding/json"
+
+// fsmDoc is the document form of an FSM. It holds both the StateTrans and
+// the position of the FSM within it.
+type fsmDoc struct {
+	StateTrans stDoc  `json:"stateTrans"`
+	Current    string `json:"current"`
+	Prior      string `json:"prior"`
+}
+
+// MarshalJSON returns a JSON document capturing both the StateTrans of the
+// FSM and its current and prior states. This satisfies the json.Marshaler
+// interface. The Underlying is not recorded. The FSM can be reconstructed
+// from the document by LoadFSM.
+//
+// The document is deterministic: the states, and the next states of each
+// state, are always given in sorted order so that the same FSM gives
+// exactly the same document. It can therefore be kept under source control
+// or compared with a golden file in tests.
+func (f *FSM) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fsmDoc{
+		StateTrans: f.st.mkDoc(),
+		Current:    f.current.name,
+		Prior:      f.prior.name,
+	})
+}
+
+// LoadFSM constructs a new FSM from the JSON document in data, which should
+// have the form generated by FSM.MarshalJSON. The StateTrans is rebuilt with
+// the same validation as for UnmarshalYAML and the current and prior states
+// of the FSM are restored; they must both be known states, though they may
+// be given by an alias or, if the StateTrans matches names regardless of
+// case, in any case. The Underlying is set on the new FSM and its SetFSM
+// method, followed by its Init method if it is an Initialiser, is called
+// once the position has been restored. If there are any problems a nil FSM
+// and the error are returned.
+func LoadFSM(data []byte, u Underlying) (*FSM, error) {
+	var doc fsmDoc
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	st, err := doc.StateTrans.stateTrans()
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := st.getState(doc.Current)
+	if err != nil {
+		return nil, err
+	}
+	prior, err := st.getState(doc.Prior)
+	if err != nil {
+		return nil, err
+	}
+
+	f := New(st, nil)
+	f.current = current
+	f.currentName.Store(current.name)
+	f.prior = prior
+	f.und = u
+	if u != nil {
+		f.callSetFSM()
+	}
+	if err := f.initUnderlying(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,248 @@
+package fsm_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+var gfc = testhelper.GoldenFileCfg{
+	DirNames:    []string{"testdata", "json"},
+	Sfx:         "json",
+	UpdFlagName: "upd-gf",
+}
+
+func init() {
+	gfc.AddUpdateFlag()
+}
+
+func TestFSMJSONRoundTrip(t *testing.T) {
+	st, err := fsm.NewStateTrans("lifecycle",
+		fsm.STPair{fsm.InitState, "start"},
+		fsm.STPair{"start", "finish"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.SetStateDesc("start", "the first state")
+
+	f := fsm.New(st, nil)
+	_ = f.ChangeState("start")
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal("couldn't marshal the FSM:", err)
+	}
+
+	expJSON := `{"stateTrans":{"name":"lifecycle",` +
+		`"transitions":{"finish":[],"init":["start"],"start":["finish"]},` +
+		`"descriptions":{"init":"the initial state",` +
+		`"start":"the first state"}},` +
+		`"current":"start","prior":"init"}`
+	testhelper.DiffString(t, "marshal", "JSON", string(data), expJSON)
+
+	var u underlying
+	u.allowChange = true
+	newF, err := fsm.LoadFSM(data, &u)
+	if err != nil {
+		t.Fatal("couldn't load the FSM:", err)
+	}
+	testhelper.DiffInt(t, "load", "SetFSM calls", u.setFSMCallCount, 1)
+	testhelper.DiffString(t, "load", "name", newF.Name(), f.Name())
+	testhelper.DiffString(t, "load", "current state",
+		newF.CurrentState(), f.CurrentState())
+	testhelper.DiffString(t, "load", "current state (atomic)",
+		newF.CurrentStateAtomic(), f.CurrentState())
+	testhelper.DiffString(t, "load", "prior state",
+		newF.PriorState(), f.PriorState())
+
+	if err = newF.ChangeState("finish"); err != nil {
+		t.Error("unexpected error changing the state of the loaded FSM:",
+			err)
+	}
+	testhelper.DiffBool(t, "load", "OnTransition called",
+		u.onTransitionCalled, true)
+}
+
+func TestFSMJSONExtraEntries(t *testing.T) {
+	const data = `{"stateTrans":{"costs":{"init":{"start":3}},` +
+		`"descriptions":{"init":"the initial state"},` +
+		`"futureFeature":{"enabled":true},"name":"lifecycle",` +
+		`"transitions":{"init":["start"],"start":[]},"version":"7"},` +
+		`"current":"init","prior":"init"}`
+
+	f, err := fsm.LoadFSM([]byte(data), nil)
+	if err != nil {
+		t.Fatal("couldn't load the FSM:", err)
+	}
+	testhelper.DiffString(t, "load", "version", f.Version(), "7")
+
+	newData, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal("couldn't marshal the FSM:", err)
+	}
+	testhelper.DiffString(t, "round trip", "JSON", string(newData), data)
+}
+
+func TestLoadFSM(t *testing.T) {
+	const stJSON = `"stateTrans":{"name":"lifecycle",` +
+		`"transitions":{"init":["start"],"start":["finish"]}}`
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		doc string
+	}{
+		{
+			ID:  testhelper.MkID("good"),
+			doc: `{` + stJSON + `,"current":"finish","prior":"start"}`,
+		},
+		{
+			ID:  testhelper.MkID("bad - unknown current state"),
+			doc: `{` + stJSON + `,"current":"nonesuch","prior":"start"}`,
+			ExpErr: testhelper.MkExpErr(
+				`lifecycle: state: "nonesuch" does not exist`),
+		},
+		{
+			ID:  testhelper.MkID("bad - unknown prior state"),
+			doc: `{` + stJSON + `,"current":"finish","prior":"nonesuch"}`,
+			ExpErr: testhelper.MkExpErr(
+				`lifecycle: state: "nonesuch" does not exist`),
+		},
+		{
+			ID: testhelper.MkID("bad - bad StateTrans"),
+			doc: `{"stateTrans":{"name":"lifecycle",` +
+				`"transitions":{"X":["start"]}},` +
+				`"current":"init","prior":"init"}`,
+			ExpErr: testhelper.MkExpErr(
+				"lifecycle: state: 'X' does not exist", "failed"),
+		},
+		{
+			ID:     testhelper.MkID("bad - not JSON"),
+			doc:    `{`,
+			ExpErr: testhelper.MkExpErr("unexpected end of JSON input"),
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := fsm.LoadFSM([]byte(tc.doc), nil)
+		testhelper.CheckExpErr(t, err, tc)
+	}
+}
+
+func TestLoadFSMAltNames(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		doc        string
+		expCurrent string
+		expPrior   string
+	}{
+		{
+			ID: testhelper.MkID("alias"),
+			doc: `{"stateTrans":{"name":"lifecycle",` +
+				`"transitions":{"init":["start"],"start":["finish"]},` +
+				`"aliases":{"fin":"finish","st":"start"}},` +
+				`"current":"fin","prior":"st"}`,
+			expCurrent: "finish",
+			expPrior:   "start",
+		},
+		{
+			ID: testhelper.MkID("fold case"),
+			doc: `{"stateTrans":{"name":"lifecycle","foldCase":true,` +
+				`"transitions":{"init":["Start"],"Start":["Finish"]}},` +
+				`"current":"FINISH","prior":"start"}`,
+			expCurrent: "Finish",
+			expPrior:   "Start",
+		},
+	}
+
+	for _, tc := range testCases {
+		f, err := fsm.LoadFSM([]byte(tc.doc), nil)
+		if err != nil {
+			t.Log(tc.IDStr())
+			t.Error("\t: unexpected error:", err)
+			continue
+		}
+		testhelper.DiffString(t, tc.IDStr(), "current state",
+			f.CurrentState(), tc.expCurrent)
+		testhelper.DiffString(t, tc.IDStr(), "current state (atomic)",
+			f.CurrentStateAtomic(), tc.expCurrent)
+		testhelper.DiffString(t, tc.IDStr(), "prior state",
+			f.PriorState(), tc.expPrior)
+	}
+}
+
+func TestFSMJSONGolden(t *testing.T) {
+	mkFSM := func() *fsm.FSM {
+		t.Helper()
+
+		st, err := fsm.NewStateTrans("review",
+			fsm.STPair{fsm.InitState, "ReadyToReview"},
+			fsm.STPair{"ReadyToReview", "UnderReview"},
+			fsm.STPair{"UnderReview", "ReadyToFix"},
+			fsm.STPair{"UnderReview", "Rejected"},
+			fsm.STPair{"UnderReview", "Approved"},
+			fsm.STPair{"ReadyToFix", "UnderReview"},
+			fsm.STPair{"Approved", "Released"})
+		if err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+		st.SetVersion("3")
+		for _, sd := range []fsm.StateDesc{
+			{Name: "Released", Desc: "in production"},
+			{Name: "Rejected", Desc: "will not be done"},
+			{Name: "Approved", Desc: "ready to release"},
+		} {
+			if err := st.SetStateDesc(sd.Name, sd.Desc); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		for _, s := range []string{"Released", "Rejected"} {
+			if err := st.SetStateGroup(s, "closed"); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		for _, e := range []struct{ event, from, to string }{
+			{"reject", "UnderReview", "Rejected"},
+			{"approve", "UnderReview", "Approved"},
+			{"requestFix", "UnderReview", "ReadyToFix"},
+		} {
+			if err := st.AddEvent(e.event, e.from, e.to); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+
+		f := fsm.New(st, nil)
+		for _, s := range []string{"ReadyToReview", "UnderReview"} {
+			if err := f.ChangeState(s); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		return f
+	}
+
+	data, err := json.Marshal(mkFSM())
+	if err != nil {
+		t.Fatal("couldn't marshal the FSM:", err)
+	}
+	for i := 0; i < 10; i++ {
+		again, err := json.Marshal(mkFSM())
+		if err != nil {
+			t.Fatal("couldn't marshal the FSM:", err)
+		}
+		if !bytes.Equal(again, data) {
+			t.Errorf("marshal %d differs from the first:\n%s\n%s",
+				i, again, data)
+			break
+		}
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "    "); err != nil {
+		t.Fatal("couldn't indent the JSON:", err)
+	}
+	indented.WriteString("\n")
+	gfc.Check(t, "review FSM", t.Name(), indented.Bytes())
+}
@@ -0,0 +1,224 @@
+package fsm
+
+import (
+	"context"
+	"time"
+)
+
+// OptFunc is the type of a function which can be passed to New to set
+// optional features of the FSM
+type OptFunc func(f *FSM)
+
+// Clock provides the time-related functions used by the FSM. The default
+// Clock uses the functions from the standard time package but an
+// alternative can be supplied through the WithClock option, for instance in
+// tests so that they do not actually sleep.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Sleep pauses for at least the given duration
+	Sleep(d time.Duration)
+}
+
+// realClock is the default Clock, it uses the standard time package
+type realClock struct{}
+
+// Now returns the current time
+func (realClock) Now() time.Time { return time.Now() }
+
+// Sleep pauses for at least the given duration
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Logger receives a record of every attempt to change the state of an FSM
+// through ChangeState. It can be supplied through the WithLogger option and
+// allows the changes of state to be observed without needing an
+// Underlying.
+type Logger interface {
+	// LogTransition is called after each attempt to change the state of
+	// the FSM with the given name from the 'from' state to the 'to'
+	// state. The error is nil if the change succeeded, in which case it
+	// is called before the Underlying's OnTransition method.
+	LogTransition(name, from, to string, err error)
+}
+
+// DeprecationLogger is an optional interface which a Logger can implement
+// if it is to be told when the FSM makes a change of state which has been
+// marked as deprecated (see StateTrans.DeprecateTransition).
+type DeprecationLogger interface {
+	// LogDeprecated is called after the FSM with the given name has
+	// changed from the 'from' state to the 'to' state using a deprecated
+	// transition. The note is the one given when the transition was
+	// deprecated. It is called after LogTransition.
+	LogDeprecated(name, from, to, note string)
+}
+
+// Tracer is used by ChangeStateCtx to record each attempted change of state,
+// for instance as an OpenTelemetry span. It can be supplied through the
+// WithTracer option; by default no tracing is done.
+type Tracer interface {
+	// StartTransition is called before the change of state from the 'from'
+	// state to the 'to' state is checked. It returns the context to be
+	// used while the change is made and a function which is called once
+	// the change is complete, after the Underlying's OnTransition method,
+	// with the error, if any, that ChangeStateCtx will return.
+	StartTransition(ctx context.Context, from, to string,
+	) (context.Context, func(err error))
+}
+
+// noopTracer is the default Tracer, it does nothing
+type noopTracer struct{}
+
+// StartTransition returns the context unchanged and a function which does
+// nothing
+func (noopTracer) StartTransition(ctx context.Context, _, _ string,
+) (context.Context, func(err error)) {
+	return ctx, func(error) {}
+}
+
+// WithClock returns an OptFunc which will set the Clock used by the FSM. A
+// nil Clock is ignored.
+func WithClock(c Clock) OptFunc {
+	return func(f *FSM) {
+		if c != nil {
+			f.clock = c
+		}
+	}
+}
+
+// WithTimeInState returns an OptFunc which will turn on the tracking of the
+// time that the FSM spends in each state. The time is measured using the
+// FSM's Clock, starting from the construction of the FSM.
+func WithTimeInState() OptFunc {
+	return func(f *FSM) {
+		f.timeInState = make(map[string]time.Duration)
+	}
+}
+
+// WithoutSetFSM returns an OptFunc which will stop New from calling the
+// SetFSM method of the Underlying. This is useful where a single Underlying
+// is shared between several FSMs and so must manage its associations with
+// them itself, for instance through a map from FSM name to FSM pointer.
+func WithoutSetFSM() OptFunc {
+	return func(f *FSM) {
+		f.skipSetFSM = true
+	}
+}
+
+// WithStrictTerminal returns an OptFunc which will make ChangeState return
+// a TerminalReached error rather than a NoTransition error when the FSM is
+// already in a terminal state. This lets the caller distinguish an attempt
+// to change the state of a completed FSM from a request for an invalid
+// change of state.
+func WithStrictTerminal() OptFunc {
+	return func(f *FSM) {
+		f.strictTerminal = true
+	}
+}
+
+// WithMaxTransitions returns an OptFunc which will limit the number of
+// changes of state that the FSM may make to maxTransitions. Once the limit
+// is reached any further attempt to change the state will return a
+// TransitionBudgetExceeded error. This can guard against endless loops, for
+// instance when driving the FSM with RunToTerminal. A maxTransitions less
+// than 1 means there is no limit.
+func WithMaxTransitions(maxTransitions int) OptFunc {
+	return func(f *FSM) {
+		f.maxTransitions = maxTransitions
+	}
+}
+
+// WithLenientFormat returns an OptFunc which will make the Format method
+// treat any verb it does not support as if it were %s rather than
+// reporting it as an error. This is useful where FSMs are logged by
+// generic formatters which may use unexpected verbs.
+func WithLenientFormat() OptFunc {
+	return func(f *FSM) {
+		f.lenientFormat = true
+	}
+}
+
+// WithHistory returns an OptFunc which will turn on the recording of the
+// changes of state of the FSM. No more than maxLen transitions are kept,
+// older ones being discarded; a maxLen less than 1 means that every
+// transition is kept.
+func WithHistory(maxLen int) OptFunc {
+	return func(f *FSM) {
+		f.keepHistory = true
+		f.maxHistoryLen = maxLen
+	}
+}
+
+// FireInitialEnter returns an OptFunc which will make New call the
+// OnTransition method of the Underlying once the Underlying has been set
+// (after SetFSM has been called, unless WithoutSetFSM is also given). This
+// lets the Underlying react to the FSM starting in the InitState in the
+// same way as it reacts to any later change of state. The prior and
+// current states will both be the InitState. By default OnTransition is
+// not called by New.
+func FireInitialEnter() OptFunc {
+	return func(f *FSM) {
+		f.fireInitialEnter = true
+	}
+}
+
+// WithLogger returns an OptFunc which will set the Logger to be told of
+// every attempt to change the state of the FSM. A nil Logger is ignored.
+func WithLogger(l Logger) OptFunc {
+	return func(f *FSM) {
+		if l != nil {
+			f.logger = l
+		}
+	}
+}
+
+// WithRecover returns an OptFunc which will make the FSM recover from any
+// panic in a method of the Underlying, including the methods of the
+// optional interfaces, rather than letting it crash the program. The value
+// recovered is passed to the handler. The effect of the panic then depends
+// on which method panicked:
+//
+// A panic in TransitionAllowed (or TransitionAllowedFrom) is treated as a
+// refusal of the change; the state is not changed and ChangeState returns a
+// ForbiddenChange error. If the Underlying is a RejectionHandler it is
+// told of the rejection as usual.
+//
+// A panic in OnTransition happens after the state has been changed and so
+// the change stands; ChangeState returns no error and the handler is the
+// only record of the panic. Note that any work that OnTransition would
+// have done after the panic, including any further change of state, will
+// not have been done.
+//
+// A panic in Redirect is treated as not redirecting, in IsTerminalOverride
+// as having no opinion and in NextEvent as not requesting an event. A
+// panic in TransitionRejected, OnRevisit or SetFSM is otherwise ignored,
+// the handler being the only record of it. A panic in Init is reported as
+// the Underlying failing to initialise and so NewErr returns an error.
+//
+// A nil handler is ignored.
+func WithRecover(handler func(r any)) OptFunc {
+	return func(f *FSM) {
+		if handler != nil {
+			f.panicHandler = handler
+		}
+	}
+}
+
+// WithTracer returns an OptFunc which will set the Tracer used by
+// ChangeStateCtx. A nil Tracer is ignored.
+func WithTracer(t Tracer) OptFunc {
+	return func(f *FSM) {
+		if t != nil {
+			f.tracer = t
+		}
+	}
+}
+
+// WithMaxEventChain returns an OptFunc which will set the maximum number of
+// events that can be fired in a chain by an Underlying which is an
+// EventChainer. A maxEvents less than 1 means that NextEvent is never
+// called. The default is DefaultMaxEventChain.
+func WithMaxEventChain(maxEvents int) OptFunc {
+	return func(f *FSM) {
+		f.maxEventChain = maxEvents
+	}
+}
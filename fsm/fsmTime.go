@@ -0,0 +1,32 @@
+package fsm
+
+import "time"
+
+// TimeInState returns a map from state name to the total time that the FSM
+// has spent in that state, including the time spent so far in the current
+// state. States which the FSM has never been in are not present. If time in
+// state is not being tracked (see WithTimeInState) a nil map is returned.
+func (f *FSM) TimeInState() map[string]time.Duration {
+	if f.timeInState == nil {
+		return nil
+	}
+
+	tis := make(map[string]time.Duration, len(f.timeInState)+1)
+	for name, d := range f.timeInState {
+		tis[name] = d
+	}
+	tis[f.current.name] += f.TimeInCurrentState()
+
+	return tis
+}
+
+// TimeInCurrentState returns the time since the FSM entered its current
+// state. If time in state is not being tracked (see WithTimeInState) zero
+// is returned.
+func (f *FSM) TimeInCurrentState() time.Duration {
+	if f.timeInState == nil {
+		return 0
+	}
+
+	return f.clock.Now().Sub(f.enteredAt)
+}
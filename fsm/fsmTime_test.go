@@ -0,0 +1,51 @@
+package fsm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestTimeInState(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "state2"},
+		fsm.STPair{"state2", "state1"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	untracked := fsm.New(st, nil)
+	if tis := untracked.TimeInState(); tis != nil {
+		t.Errorf("untracked FSM: expected a nil map, got: %v", tis)
+	}
+	testhelper.DiffInt(t, "untracked FSM", "time in current state",
+		untracked.TimeInCurrentState(), 0)
+
+	c := &fakeClock{now: time.Now()}
+	f := fsm.New(st, nil, fsm.WithTimeInState(), fsm.WithClock(c))
+
+	c.Sleep(time.Second)
+	_ = f.ChangeState("state1")
+	c.Sleep(2 * time.Second)
+	_ = f.ChangeState("state2")
+	c.Sleep(3 * time.Second)
+	_ = f.ChangeState("state1")
+	c.Sleep(4 * time.Second)
+
+	testhelper.DiffInt(t, "tracked FSM", "time in current state",
+		f.TimeInCurrentState(), 4*time.Second)
+
+	expTIS := map[string]time.Duration{
+		fsm.InitState: time.Second,
+		"state1":      6 * time.Second,
+		"state2":      3 * time.Second,
+	}
+	tis := f.TimeInState()
+	if err := testhelper.DiffVals(tis, expTIS); err != nil {
+		t.Log("tracked FSM")
+		t.Errorf("\t: time in state: %s", err)
+	}
+}
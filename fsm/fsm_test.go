@@ -1,8 +1,12 @@
 package fsm_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/nickwells/fsm.mod/fsm"
 	"github.com/nickwells/testhelper.mod/v2/testhelper"
@@ -221,6 +225,11 @@ func TestFsm(t *testing.T) {
 		t.Errorf("Unexpected StateCount: expected 4, got %d\n", stateCount)
 	}
 
+	if tranCount := st.TransitionCount(); tranCount != 4 {
+		t.Errorf("Unexpected TransitionCount: expected 4, got %d\n",
+			tranCount)
+	}
+
 	var u underlying
 	f := fsm.New(st, &u)
 	if u.setFSMCallCount != 1 {
@@ -255,3 +264,2142 @@ func TestFsm(t *testing.T) {
 		}
 	}
 }
+
+// fakeClock is a Clock which doesn't sleep but records the time it has
+// been asked to sleep for
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+// Now returns the fake time
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// Sleep advances the fake time and records the duration
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.slept = append(c.slept, d)
+}
+
+// flakyUnderlying is an Underlying which forbids the first few transitions
+type flakyUnderlying struct {
+	failCount  int
+	callCount  int
+	onTranCall int
+}
+
+// (u flakyUnderlying)TransitionAllowed ...
+func (u *flakyUnderlying) TransitionAllowed(_ *fsm.FSM, _ string) error {
+	u.callCount++
+	if u.callCount <= u.failCount {
+		return errors.New(undErrStr)
+	}
+	return nil
+}
+
+// (u flakyUnderlying)OnTransition ...
+func (u *flakyUnderlying) OnTransition(_ *fsm.FSM) {
+	u.onTranCall++
+}
+
+// (u flakyUnderlying)SetFSM ...
+func (u *flakyUnderlying) SetFSM(_ *fsm.FSM) {}
+
+func TestChangeStateRetry(t *testing.T) {
+	const delay = time.Second
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		failCount     int
+		attempts      int
+		newState      string
+		expCallCount  int
+		expSleepCount int
+		expState      string
+	}{
+		{
+			ID:           testhelper.MkID("good - first attempt"),
+			attempts:     3,
+			newState:     "state1",
+			expCallCount: 1,
+			expState:     "state1",
+		},
+		{
+			ID:            testhelper.MkID("good - third attempt"),
+			failCount:     2,
+			attempts:      3,
+			newState:      "state1",
+			expCallCount:  3,
+			expSleepCount: 2,
+			expState:      "state1",
+		},
+		{
+			ID:            testhelper.MkID("bad - attempts exhausted"),
+			failCount:     5,
+			attempts:      3,
+			newState:      "state1",
+			expCallCount:  3,
+			expSleepCount: 2,
+			expState:      fsm.InitState,
+			ExpErr:        testhelper.MkExpErr("is forbidden", undErrStr),
+		},
+		{
+			ID:           testhelper.MkID("bad - zero attempts"),
+			failCount:    5,
+			newState:     "state1",
+			expCallCount: 1,
+			expState:     fsm.InitState,
+			ExpErr:       testhelper.MkExpErr("is forbidden", undErrStr),
+		},
+		{
+			ID:        testhelper.MkID("bad - no transition, no retry"),
+			failCount: 5,
+			attempts:  3,
+			newState:  "final",
+			expState:  fsm.InitState,
+			ExpErr: testhelper.MkExpErr(
+				"There is no valid transition from"),
+		},
+	}
+
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "final"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	for _, tc := range testCases {
+		u := &flakyUnderlying{failCount: tc.failCount}
+		c := &fakeClock{}
+		f := fsm.New(st, u, fsm.WithClock(c))
+
+		err := f.ChangeStateRetry(tc.newState, tc.attempts, delay)
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffInt(t, tc.IDStr(), "TransitionAllowed calls",
+			u.callCount, tc.expCallCount)
+		testhelper.DiffInt(t, tc.IDStr(), "sleeps",
+			len(c.slept), tc.expSleepCount)
+		testhelper.DiffString(t, tc.IDStr(), "current state",
+			f.CurrentState(), tc.expState)
+		expOnTranCall := 0
+		if err == nil {
+			expOnTranCall = 1
+		}
+		testhelper.DiffInt(t, tc.IDStr(), "OnTransition calls",
+			u.onTranCall, expOnTranCall)
+	}
+}
+
+func TestWithoutSetFSM(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var u underlying
+	u.allowChange = true
+	f := fsm.New(st, &u, fsm.WithoutSetFSM())
+	testhelper.DiffInt(t, "WithoutSetFSM", "SetFSM calls",
+		u.setFSMCallCount, 0)
+
+	if err = f.ChangeState("state1"); err != nil {
+		t.Error("unexpected error changing state:", err)
+	}
+	testhelper.DiffBool(t, "WithoutSetFSM", "TransitionAllowed called",
+		u.transitionAllowedCalled, true)
+	testhelper.DiffBool(t, "WithoutSetFSM", "OnTransition called",
+		u.onTransitionCalled, true)
+}
+
+func TestFireInitialEnter(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var u underlying
+	_ = fsm.New(st, &u)
+	testhelper.DiffBool(t, "default", "OnTransition called",
+		u.onTransitionCalled, false)
+
+	u.Reset()
+	f := fsm.New(st, &u, fsm.FireInitialEnter())
+	testhelper.DiffBool(t, "FireInitialEnter", "OnTransition called",
+		u.onTransitionCalled, true)
+	testhelper.DiffInt(t, "FireInitialEnter", "SetFSM calls",
+		u.setFSMCallCount, 1)
+	testhelper.DiffString(t, "FireInitialEnter", "current state",
+		f.CurrentState(), fsm.InitState)
+	testhelper.DiffBool(t, "FireInitialEnter", "TransitionAllowed called",
+		u.transitionAllowedCalled, false)
+
+	f = fsm.New(st, nil, fsm.FireInitialEnter())
+	testhelper.DiffString(t, "nil Underlying", "current state",
+		f.CurrentState(), fsm.InitState)
+}
+
+func TestDetachUnderlying(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "state2"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var u underlying
+	f := fsm.New(st, &u)
+
+	if detached := f.DetachUnderlying(); detached != &u {
+		t.Errorf("DetachUnderlying: expected %p, got %p", &u, detached)
+	}
+	if err = f.ChangeState("state1"); err != nil {
+		t.Error("unexpected error changing state while detached:", err)
+	}
+	testhelper.DiffBool(t, "detached", "TransitionAllowed called",
+		u.transitionAllowedCalled, false)
+	testhelper.DiffBool(t, "detached", "OnTransition called",
+		u.onTransitionCalled, false)
+
+	f.AttachUnderlying(&u)
+	testhelper.DiffInt(t, "attached", "SetFSM calls", u.setFSMCallCount, 2)
+	err = f.ChangeState("state2")
+	testhelper.CheckExpErrWithID(t, "attached", err,
+		testhelper.MkExpErr("is forbidden", undErrStr))
+	testhelper.DiffBool(t, "attached", "TransitionAllowed called",
+		u.transitionAllowedCalled, true)
+}
+
+// fromUnderlying is an Underlying which also satisfies the
+// TransitionChecker interface
+type fromUnderlying struct {
+	underlying
+	from, to string
+}
+
+// (u fromUnderlying)TransitionAllowedFrom ...
+func (u *fromUnderlying) TransitionAllowedFrom(_ *fsm.FSM, from, to string,
+) error {
+	u.from, u.to = from, to
+	if from == fsm.InitState {
+		return nil
+	}
+	return errors.New(undErrStr)
+}
+
+func TestTransitionAllowedFrom(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "state2"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var u fromUnderlying
+	f := fsm.New(st, &u)
+
+	if err = f.ChangeState("state1"); err != nil {
+		t.Error("unexpected error changing state:", err)
+	}
+	testhelper.DiffString(t, "first change", "from", u.from, fsm.InitState)
+	testhelper.DiffString(t, "first change", "to", u.to, "state1")
+	testhelper.DiffBool(t, "first change", "TransitionAllowed called",
+		u.transitionAllowedCalled, false)
+	testhelper.DiffBool(t, "first change", "OnTransition called",
+		u.onTransitionCalled, true)
+
+	err = f.ChangeState("state2")
+	testhelper.CheckExpErrWithID(t, "second change", err,
+		testhelper.MkExpErr("is forbidden", undErrStr))
+	testhelper.DiffString(t, "second change", "from", u.from, "state1")
+	testhelper.DiffString(t, "second change", "to", u.to, "state2")
+}
+
+// pickyUnderlying is an Underlying which only allows changes to the given
+// states
+type pickyUnderlying struct {
+	allowed map[string]bool
+}
+
+// (u pickyUnderlying)TransitionAllowed ...
+func (u pickyUnderlying) TransitionAllowed(_ *fsm.FSM, newState string,
+) error {
+	if u.allowed[newState] {
+		return nil
+	}
+	return errors.New(undErrStr)
+}
+
+// (u pickyUnderlying)OnTransition ...
+func (u pickyUnderlying) OnTransition(_ *fsm.FSM) {}
+
+// (u pickyUnderlying)SetFSM ...
+func (u pickyUnderlying) SetFSM(_ *fsm.FSM) {}
+
+func TestNextStatesAllowed(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "C"},
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{fsm.InitState, "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		und        fsm.Underlying
+		expAllowed []string
+		expStuck   bool
+	}{
+		{
+			ID:         testhelper.MkID("no underlying"),
+			expAllowed: []string{"A", "B", "C"},
+		},
+		{
+			ID: testhelper.MkID("some allowed"),
+			und: pickyUnderlying{
+				allowed: map[string]bool{"C": true, "A": true},
+			},
+			expAllowed: []string{"A", "C"},
+		},
+		{
+			ID:         testhelper.MkID("none allowed"),
+			und:        pickyUnderlying{},
+			expAllowed: []string{},
+			expStuck:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, tc.und)
+		testhelper.DiffStringSlice(t, tc.IDStr(), "allowed next states",
+			f.NextStatesAllowed(), tc.expAllowed)
+		testhelper.DiffBool(t, tc.IDStr(), "stuck", f.IsStuck(), tc.expStuck)
+	}
+
+	f := fsm.New(st, pickyUnderlying{allowed: map[string]bool{"A": true}})
+	_ = f.ChangeState("A")
+	testhelper.DiffBool(t, "terminal", "stuck", f.IsStuck(), false)
+}
+
+func TestNextStateStatus(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{fsm.InitState, "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	f := fsm.New(st, pickyUnderlying{allowed: map[string]bool{"A": true}})
+	status := f.NextStateStatus()
+	testhelper.DiffInt(t, "status", "entries", len(status), 2)
+
+	if err, ok := status["A"]; !ok || err != nil {
+		t.Errorf("status[A]: expected a nil error, got: %v (present: %t)",
+			err, ok)
+	}
+
+	var fc fsm.ForbiddenChange
+	if !errors.As(status["B"], &fc) {
+		t.Errorf("status[B]: expected a ForbiddenChange error, got: %v",
+			status["B"])
+	} else {
+		testhelper.DiffString(t, "status[B]", "ToState", fc.ToState, "B")
+		testhelper.DiffString(t, "status[B]", "underlying error",
+			fc.UndError.Error(), undErrStr)
+	}
+}
+
+func TestStepsTo(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "X"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "C"},
+		fsm.STPair{"X", "C"},
+		fsm.STPair{"X", "Y"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.SetSuccessorResolver("B", func(_ *fsm.FSM) []string {
+		return []string{"Y"}
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		path     []string
+		target   string
+		expSteps int
+	}{
+		{
+			ID:     testhelper.MkID("current state"),
+			target: fsm.InitState,
+		},
+		{
+			ID:       testhelper.MkID("shortest of two paths"),
+			target:   "C",
+			expSteps: 2,
+		},
+		{
+			ID:       testhelper.MkID("from a later state"),
+			path:     []string{"A"},
+			target:   "C",
+			expSteps: 2,
+		},
+		{
+			ID:       testhelper.MkID("through a successor resolver"),
+			path:     []string{"A"},
+			target:   "Y",
+			expSteps: 2,
+		},
+		{
+			ID:     testhelper.MkID("unreachable"),
+			path:   []string{"A"},
+			target: "X",
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": "X" cannot be reached from "A"`),
+		},
+		{
+			ID:     testhelper.MkID("unknown"),
+			target: "nonesuch",
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": "nonesuch" is not a known state`),
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil)
+		for _, s := range tc.path {
+			if err := f.ChangeState(s); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		steps, err := f.StepsTo(tc.target)
+		if testhelper.CheckExpErr(t, err, tc) && err == nil {
+			testhelper.DiffInt(t, tc.IDStr(), "steps", steps, tc.expSteps)
+		}
+	}
+}
+
+func TestWhyNot(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{"A", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	f := fsm.New(st, pickyUnderlying{allowed: map[string]bool{"A": true}})
+
+	testCases := []struct {
+		testhelper.ID
+		newState  string
+		expValid  bool
+		expReason string
+	}{
+		{
+			ID:       testhelper.MkID("allowed"),
+			newState: "A",
+			expValid: true,
+		},
+		{
+			ID:        testhelper.MkID("unknown"),
+			newState:  "nonesuch",
+			expReason: "unknown state",
+		},
+		{
+			ID:        testhelper.MkID("no transition"),
+			newState:  "C",
+			expReason: "no transition from " + fsm.InitState,
+		},
+		{
+			ID:        testhelper.MkID("forbidden"),
+			newState:  "B",
+			expReason: "forbidden: " + undErrStr,
+		},
+	}
+
+	for _, tc := range testCases {
+		valid, reason := f.WhyNot(tc.newState)
+		testhelper.DiffBool(t, tc.IDStr(), "valid", valid, tc.expValid)
+		testhelper.DiffString(t, tc.IDStr(), "reason", reason, tc.expReason)
+	}
+
+	testhelper.DiffString(t, "after", "current state",
+		f.CurrentState(), fsm.InitState)
+}
+
+func TestQualifiedState(t *testing.T) {
+	st, err := fsm.NewStateTrans("bug",
+		fsm.STPair{fsm.InitState, "UnderReview"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	f := fsm.New(st, nil)
+	testhelper.DiffString(t, "new", "qualified state",
+		f.QualifiedState(), "bug/init")
+	_ = f.ChangeState("UnderReview")
+	testhelper.DiffString(t, "changed", "qualified state",
+		f.QualifiedState(), "bug/UnderReview")
+}
+
+func TestMachine(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var m fsm.Machine = fsm.New(st, nil)
+	if err = m.ChangeState("state1"); err != nil {
+		t.Error("unexpected error changing state:", err)
+	}
+	testhelper.DiffString(t, "Machine", "current state",
+		m.CurrentState(), "state1")
+	testhelper.DiffBool(t, "Machine", "terminal", m.IsInTerminalState(), true)
+}
+
+func TestStrictTerminal(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "final"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		opts     []fsm.OptFunc
+		newState string
+	}{
+		{
+			ID:       testhelper.MkID("strict"),
+			opts:     []fsm.OptFunc{fsm.WithStrictTerminal()},
+			newState: fsm.InitState,
+			ExpErr: testhelper.MkExpErr(
+				`Cannot change from "final" to "init":` +
+					` "final" is a terminal state`),
+		},
+		{
+			ID:       testhelper.MkID("strict - unknown state"),
+			opts:     []fsm.OptFunc{fsm.WithStrictTerminal()},
+			newState: "nonesuch",
+			ExpErr:   testhelper.MkExpErr("is not a known state"),
+		},
+		{
+			ID:       testhelper.MkID("not strict"),
+			newState: fsm.InitState,
+			ExpErr: testhelper.MkExpErr(
+				`There is no valid transition from "final" to "init"`),
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil, tc.opts...)
+		_ = f.ChangeState("state1")
+		_ = f.ChangeState("final")
+		err := f.ChangeState(tc.newState)
+		testhelper.CheckExpErr(t, err, tc)
+	}
+
+	f := fsm.New(st, nil, fsm.WithStrictTerminal())
+	err = f.ChangeState("final")
+	testhelper.CheckExpErrWithID(t, "strict - not terminal", err,
+		testhelper.MkExpErr(
+			`There is no valid transition from "init" to "final"`))
+}
+
+func TestAppendNextStates(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "D"},
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{fsm.InitState, "C"},
+		fsm.STPair{fsm.InitState, "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	f := fsm.New(st, nil)
+
+	buf := []string{"X"}
+	buf = f.AppendNextStates(buf)
+	testhelper.DiffStringSlice(t, "append", "next states",
+		buf, []string{"X", "A", "B", "C", "D"})
+
+	// with no successor resolver a large enough buffer is simply reused
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = f.AppendNextStates(buf[:0])
+	})
+	testhelper.DiffFloat(t, "reused buffer", "allocations", allocs, 0, 0)
+	testhelper.DiffStringSlice(t, "reused buffer", "next states",
+		buf, []string{"A", "B", "C", "D"})
+}
+
+// rejectUnderlying is an Underlying which also satisfies the
+// RejectionHandler interface
+type rejectUnderlying struct {
+	underlying
+	rejectedTo  string
+	rejectedErr error
+}
+
+// (u rejectUnderlying)TransitionRejected ...
+func (u *rejectUnderlying) TransitionRejected(_ *fsm.FSM, to string,
+	err error,
+) {
+	u.rejectedTo = to
+	u.rejectedErr = err
+}
+
+func TestTransitionRejected(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "state2"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var u rejectUnderlying
+	f := fsm.New(st, &u)
+
+	_ = f.ChangeState("state2")
+	testhelper.DiffString(t, "no transition", "rejected state",
+		u.rejectedTo, "")
+
+	err = f.ChangeState("state1")
+	testhelper.CheckExpErrWithID(t, "forbidden", err,
+		testhelper.MkExpErr("is forbidden", undErrStr))
+	testhelper.DiffString(t, "forbidden", "rejected state",
+		u.rejectedTo, "state1")
+	testhelper.DiffErr(t, "forbidden", "rejection error",
+		u.rejectedErr, errors.New(undErrStr))
+
+	u.rejectedTo = ""
+	u.allowChange = true
+	_ = f.ChangeState("state1")
+	testhelper.DiffString(t, "allowed", "rejected state", u.rejectedTo, "")
+}
+
+func TestGlobalGuard(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "state2"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	calls := []string{}
+	st.AddGlobalGuard(func(_ *fsm.FSM, from, to string) error {
+		calls = append(calls, "guard1: "+from+"->"+to)
+		return nil
+	})
+	st.AddGlobalGuard(func(_ *fsm.FSM, from, to string) error {
+		calls = append(calls, "guard2: "+from+"->"+to)
+		if to == "state2" {
+			return errors.New("no entry to state2")
+		}
+		return nil
+	})
+
+	var u underlying
+	u.allowChange = true
+	f := fsm.New(st, &u)
+
+	if err = f.ChangeState("state1"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	testhelper.DiffStringSlice(t, "allowed", "guard calls", calls,
+		[]string{"guard1: init->state1", "guard2: init->state1"})
+
+	calls = calls[:0]
+	u.Reset()
+	u.allowChange = true
+	err = f.ChangeState("state2")
+	testhelper.CheckExpErrWithID(t, "forbidden", err,
+		testhelper.MkExpErr("is forbidden", "no entry to state2"))
+	testhelper.DiffStringSlice(t, "forbidden", "guard calls", calls,
+		[]string{"guard1: state1->state2", "guard2: state1->state2"})
+	testhelper.DiffBool(t, "forbidden", "TransitionAllowed called",
+		u.transitionAllowedCalled, false)
+}
+
+type terminalUnderlying struct {
+	underlying
+	opinions map[string]bool
+}
+
+// (u terminalUnderlying)IsTerminalOverride ...
+func (u *terminalUnderlying) IsTerminalOverride(_ *fsm.FSM, state string,
+) (bool, bool) {
+	isTerminal, ok := u.opinions[state]
+	return isTerminal, ok
+}
+
+func TestTerminalOverride(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "closed"},
+		fsm.STPair{"closed", "reopened"},
+		fsm.STPair{"reopened", "done"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	u := terminalUnderlying{
+		opinions: map[string]bool{
+			"closed": true,
+			"done":   false,
+		},
+	}
+	u.allowChange = true
+	f := fsm.New(st, &u)
+
+	testCases := []struct {
+		testhelper.ID
+		state       string
+		expTerminal bool
+	}{
+		{
+			ID:    testhelper.MkID("no opinion - not terminal"),
+			state: fsm.InitState,
+		},
+		{
+			ID:          testhelper.MkID("opinion - terminal"),
+			state:       "closed",
+			expTerminal: true,
+		},
+		{
+			ID:    testhelper.MkID("no opinion - reopened"),
+			state: "reopened",
+		},
+		{
+			ID:    testhelper.MkID("opinion - not terminal"),
+			state: "done",
+		},
+	}
+
+	for _, tc := range testCases {
+		if tc.state != f.CurrentState() {
+			if err := f.ChangeState(tc.state); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		testhelper.DiffBool(t, tc.IDStr(), "terminal",
+			f.IsInTerminalState(), tc.expTerminal)
+	}
+
+	f.DetachUnderlying()
+	testhelper.DiffBool(t, "no underlying", "terminal",
+		f.IsInTerminalState(), true)
+}
+
+func TestUnreachableFromCurrent(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{fsm.InitState, "state3"},
+		fsm.STPair{"state1", "state2"},
+		fsm.STPair{"state2", "state1"},
+		fsm.STPair{"state2", "done"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		path         []string
+		expect       []string
+		expReachable int
+	}{
+		{
+			ID:           testhelper.MkID("init"),
+			expect:       []string{fsm.InitState},
+			expReachable: 4,
+		},
+		{
+			ID:           testhelper.MkID("in a cycle"),
+			path:         []string{"state1"},
+			expect:       []string{fsm.InitState, "state3"},
+			expReachable: 3,
+		},
+		{
+			ID:   testhelper.MkID("terminal"),
+			path: []string{"state1", "state2", "done"},
+			expect: []string{
+				"done", fsm.InitState, "state1", "state2", "state3",
+			},
+			expReachable: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil)
+		for _, s := range tc.path {
+			if err := f.ChangeState(s); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		testhelper.DiffStringSlice(t, tc.IDStr(), "unreachable states",
+			f.UnreachableFromCurrent(), tc.expect)
+		testhelper.DiffInt(t, tc.IDStr(), "reachable state count",
+			f.ReachableStateCount(), tc.expReachable)
+	}
+}
+
+func TestReachabilityWithResolver(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "X"},
+		fsm.STPair{"X", "B"},
+		fsm.STPair{"B", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.SetSuccessorResolver("A", func(_ *fsm.FSM) []string {
+		return []string{"B"}
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	f := fsm.New(st, nil)
+	if err := f.ChangeState("A"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	const id = "resolver from A to B"
+	steps, err := f.StepsTo("C")
+	if err != nil {
+		t.Errorf("%s: unexpected error: %v", id, err)
+	} else {
+		testhelper.DiffInt(t, id, "steps to C", steps, 2)
+	}
+	testhelper.DiffStringSlice(t, id, "unreachable states",
+		f.UnreachableFromCurrent(), []string{"A", "X", fsm.InitState})
+	testhelper.DiffInt(t, id, "reachable state count",
+		f.ReachableStateCount(), 2)
+}
+
+type redirectUnderlying struct {
+	underlying
+	redirects map[string]string
+}
+
+// (u redirectUnderlying)Redirect ...
+func (u *redirectUnderlying) Redirect(_ *fsm.FSM, requested string,
+) (string, bool) {
+	target, ok := u.redirects[requested]
+	return target, ok
+}
+
+func TestRedirect(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "pending"},
+		fsm.STPair{"pending", "approved"},
+		fsm.STPair{"pending", "manualReview"},
+		fsm.STPair{"pending", "rejected"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	u := redirectUnderlying{
+		redirects: map[string]string{
+			"approved": "manualReview",
+			"rejected": fsm.InitState,
+		},
+	}
+	u.allowChange = true
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		newState string
+		expState string
+	}{
+		{
+			ID:       testhelper.MkID("not redirected"),
+			newState: "manualReview",
+			expState: "manualReview",
+		},
+		{
+			ID:       testhelper.MkID("redirected"),
+			newState: "approved",
+			expState: "manualReview",
+		},
+		{
+			ID:       testhelper.MkID("redirected - no transition"),
+			newState: "rejected",
+			expState: "pending",
+			ExpErr: testhelper.MkExpErr(`FSM: "testFSM":` +
+				` There is no valid transition from "pending" to "init"`),
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, &u)
+		if err := f.ChangeState("pending"); err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+		err := f.ChangeState(tc.newState)
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffString(t, tc.IDStr(), "current state",
+			f.CurrentState(), tc.expState)
+	}
+}
+
+type recordingLogger struct {
+	records []string
+}
+
+// (l recordingLogger)LogTransition ...
+func (l *recordingLogger) LogTransition(name, from, to string, err error) {
+	rec := name + ": " + from + " -> " + to
+	if err != nil {
+		rec += " failed"
+	}
+	l.records = append(l.records, rec)
+}
+
+func TestWithLogger(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "state2"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var l recordingLogger
+	f := fsm.New(st, nil, fsm.WithLogger(&l))
+
+	_ = f.ChangeState("state1")
+	_ = f.ChangeState("nonesuch")
+	_ = f.ChangeState("state1")
+	_ = f.ChangeState("state2")
+
+	testhelper.DiffStringSlice(t, "logged", "records", l.records,
+		[]string{
+			"testFSM: init -> state1",
+			"testFSM: state1 -> nonesuch failed",
+			"testFSM: state1 -> state1 failed",
+			"testFSM: state1 -> state2",
+		})
+
+	f = fsm.New(st, nil, fsm.WithLogger(nil))
+	if err := f.ChangeState("state1"); err != nil {
+		t.Error("nil Logger: unexpected error:", err)
+	}
+}
+
+func TestRunToTerminal(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"A", "done"},
+		fsm.STPair{"B", "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	first := func(_ string, next []string) (string, bool) {
+		return next[0], true
+	}
+	last := func(_ string, next []string) (string, bool) {
+		return next[len(next)-1], true
+	}
+	stopAtA := func(current string, next []string) (string, bool) {
+		return next[0], current != "A"
+	}
+	bad := func(_ string, _ []string) (string, bool) {
+		return "nonesuch", true
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		und      fsm.Underlying
+		maxSteps int
+		choose   func(string, []string) (string, bool)
+		expState string
+	}{
+		{
+			ID:       testhelper.MkID("reaches terminal"),
+			maxSteps: 10,
+			choose:   last,
+			expState: "done",
+		},
+		{
+			ID:       testhelper.MkID("chooser stops"),
+			choose:   stopAtA,
+			expState: "A",
+		},
+		{
+			ID:       testhelper.MkID("dead end"),
+			und:      pickyUnderlying{allowed: map[string]bool{"A": true}},
+			choose:   first,
+			expState: "A",
+		},
+		{
+			ID:       testhelper.MkID("too many steps"),
+			maxSteps: 5,
+			choose:   first,
+			expState: "A",
+			ExpErr: testhelper.MkExpErr(`FSM: "testFSM":` +
+				" no terminal state was reached after 5 steps"),
+		},
+		{
+			ID:       testhelper.MkID("bad choice"),
+			choose:   bad,
+			expState: fsm.InitState,
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": "nonesuch" is not a known state`),
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, tc.und)
+		err := f.RunToTerminal(tc.maxSteps, tc.choose)
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffString(t, tc.IDStr(), "final state",
+			f.CurrentState(), tc.expState)
+	}
+}
+
+type deprecationLogger struct {
+	recordingLogger
+}
+
+// (l deprecationLogger)LogDeprecated ...
+func (l *deprecationLogger) LogDeprecated(name, from, to, note string) {
+	l.records = append(l.records,
+		name+": "+from+" -> "+to+" deprecated: "+note)
+}
+
+func TestDeprecatedTransitions(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "old"},
+		fsm.STPair{fsm.InitState, "new"},
+		fsm.STPair{"old", "new"},
+		fsm.STPair{"new", "old"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testhelper.DiffSlice(t, "none deprecated", "transitions",
+		st.DeprecatedTransitions(), []fsm.STPair{})
+
+	err = st.DeprecateTransition(fsm.InitState, "old", "use new")
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.DeprecateTransition("new", "old", "old is going")
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.DeprecateTransition("old", fsm.InitState, "")
+	testhelper.CheckExpErrWithID(t, "no transition", err,
+		testhelper.MkExpErr(
+			`testFSM: there is no transition from "old" to "init"`))
+	err = st.DeprecateTransition("nonesuch", "old", "")
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`))
+
+	testhelper.DiffSlice(t, "deprecated", "transitions",
+		st.DeprecatedTransitions(), []fsm.STPair{
+			{From: fsm.InitState, To: "old"},
+			{From: "new", To: "old"},
+		})
+
+	var l deprecationLogger
+	f := fsm.New(st, nil, fsm.WithLogger(&l))
+	for _, s := range []string{"old", "new", "old"} {
+		if err := f.ChangeState(s); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	testhelper.DiffStringSlice(t, "deprecated", "log records", l.records,
+		[]string{
+			"testFSM: init -> old",
+			"testFSM: init -> old deprecated: use new",
+			"testFSM: old -> new",
+			"testFSM: new -> old",
+			"testFSM: new -> old deprecated: old is going",
+		})
+}
+
+func TestForbidTransition(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "a"},
+		fsm.STPair{fsm.InitState, "b"},
+		fsm.STPair{"a", "b"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testhelper.DiffSlice(t, "none forbidden", "transitions",
+		st.ForbiddenTransitions(), []fsm.STPair{})
+
+	if err = st.ForbidTransition(fsm.InitState, "a"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.ForbidTransition("b", "a")
+	testhelper.CheckExpErrWithID(t, "no transition", err,
+		testhelper.MkExpErr(
+			`testFSM: there is no transition from "b" to "a"`))
+	err = st.ForbidTransition("nonesuch", "a")
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`))
+
+	testhelper.DiffSlice(t, "forbidden", "transitions",
+		st.ForbiddenTransitions(), []fsm.STPair{
+			{From: fsm.InitState, To: "a"},
+		})
+
+	u := underlying{allowChange: true}
+	f := fsm.New(st, &u)
+	err = f.ChangeState("a")
+	testhelper.CheckExpErrWithID(t, "forbidden", err,
+		testhelper.MkExpErr(
+			`FSM: "testFSM": The change from "init" to "a" is forbidden:`+
+				` the transition has been forbidden`))
+	var fc fsm.ForbiddenChange
+	testhelper.DiffBool(t, "forbidden", "is ForbiddenChange",
+		errors.As(err, &fc), true)
+	testhelper.DiffBool(t, "forbidden", "underlying consulted",
+		u.transitionAllowedCalled, false)
+
+	if err = st.AllowTransition(fsm.InitState, "a"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	testhelper.DiffSlice(t, "allowed", "transitions",
+		st.ForbiddenTransitions(), []fsm.STPair{})
+	if err = f.ChangeState("a"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "open"},
+		fsm.STPair{"open", "closed"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	err = st.AddReopen("open", fsm.InitState)
+	testhelper.CheckExpErrWithID(t, "not terminal", err,
+		testhelper.MkExpErr(`testFSM: state: "open" is not a terminal state`))
+	err = st.AddReopen("closed", "nonesuch")
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`))
+	if err = st.AddReopen("closed", "open"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var u underlying
+	u.allowChange = true
+	f := fsm.New(st, &u, fsm.WithStrictTerminal())
+	_ = f.ChangeState("open")
+
+	err = f.Reopen("closed")
+	testhelper.CheckExpErrWithID(t, "not a reopen transition", err,
+		testhelper.MkExpErr(`There is no valid transition`))
+
+	_ = f.ChangeState("closed")
+	testhelper.DiffBool(t, "closed", "terminal", f.IsInTerminalState(), true)
+	testhelper.DiffStringSlice(t, "closed", "next states",
+		f.NextStates(), []string{})
+
+	err = f.ChangeState("open")
+	testhelper.CheckExpErrWithID(t, "ChangeState from closed", err,
+		testhelper.MkExpErr(`"closed" is a terminal state`))
+
+	u.Reset()
+	err = f.Reopen("open")
+	testhelper.CheckExpErrWithID(t, "reopen forbidden", err,
+		testhelper.MkExpErr("is forbidden", undErrStr))
+
+	u.allowChange = true
+	if err = f.Reopen("open"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	testhelper.DiffString(t, "reopened", "current state",
+		f.CurrentState(), "open")
+	testhelper.DiffString(t, "reopened", "prior state",
+		f.PriorState(), "closed")
+	testhelper.DiffBool(t, "reopened", "OnTransition called",
+		u.onTransitionCalled, true)
+}
+
+func TestCurrentStateAtomic(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "state2"},
+		fsm.STPair{"state2", "state1"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	f := fsm.New(st, nil)
+	testhelper.DiffString(t, "new", "current state",
+		f.CurrentStateAtomic(), fsm.InitState)
+
+	done := make(chan struct{})
+	seen := make(chan map[string]bool)
+	go func() {
+		names := map[string]bool{}
+		for {
+			select {
+			case <-done:
+				seen <- names
+				return
+			default:
+				names[f.CurrentStateAtomic()] = true
+			}
+		}
+	}()
+
+	_ = f.ChangeState("state1")
+	for i := 0; i < 100; i++ {
+		_ = f.ChangeState("state2")
+		_ = f.ChangeState("state1")
+	}
+	close(done)
+
+	for name := range <-seen {
+		if !st.HasState(name) {
+			t.Errorf("concurrent read: unexpected state: %q", name)
+		}
+	}
+	testhelper.DiffString(t, "changed", "current state",
+		f.CurrentStateAtomic(), f.CurrentState())
+}
+
+func TestRateLimit(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "ready"},
+		fsm.STPair{"ready", "review"},
+		fsm.STPair{"review", "ready"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	err = st.SetRateLimit("review", fsm.InitState, time.Minute)
+	testhelper.CheckExpErrWithID(t, "no transition", err,
+		testhelper.MkExpErr(
+			`testFSM: there is no transition from "review" to "init"`))
+	if err = st.SetRateLimit("review", "ready", time.Minute); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	c := &fakeClock{now: time.Now()}
+	f := fsm.New(st, nil, fsm.WithClock(c))
+	for _, s := range []string{"ready", "review", "ready", "review"} {
+		if err := f.ChangeState(s); err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+	}
+
+	c.Sleep(20 * time.Second)
+	valid, reason := f.WhyNot("ready")
+	testhelper.DiffBool(t, "too soon", "WhyNot valid", valid, false)
+	testhelper.DiffString(t, "too soon", "WhyNot reason", reason,
+		`rate limited: FSM: "testFSM":`+
+			` The change from "review" to "ready" is too soon:`+
+			" it was last made 20s ago, the minimum interval is 1m0s")
+	testhelper.DiffStringSlice(t, "too soon", "next states allowed",
+		f.NextStatesAllowed(), []string{})
+	testhelper.DiffBool(t, "too soon", "stuck", f.IsStuck(), true)
+	if !errors.As(f.NextStateStatus()["ready"], new(fsm.RateLimited)) {
+		t.Errorf("too soon: expected a RateLimited status, got: %v",
+			f.NextStateStatus()["ready"])
+	}
+
+	err = f.ChangeState("ready")
+	testhelper.CheckExpErrWithID(t, "too soon", err,
+		testhelper.MkExpErr(`The change from "review" to "ready" is too soon`,
+			"last made 20s ago, the minimum interval is 1m0s"))
+	var rl fsm.RateLimited
+	if errors.As(err, &rl) {
+		testhelper.DiffInt(t, "too soon", "elapsed",
+			rl.Elapsed, 20*time.Second)
+	} else {
+		t.Errorf("too soon: expected a RateLimited error, got: %v", err)
+	}
+
+	c.Sleep(40 * time.Second)
+	testhelper.DiffStringSlice(t, "after the interval", "next states allowed",
+		f.NextStatesAllowed(), []string{"ready"})
+	if err = f.ChangeState("ready"); err != nil {
+		t.Error("after the interval: unexpected error:", err)
+	}
+
+	if err = st.SetRateLimit("review", "ready", 0); err != nil {
+		t.Fatal("couldn't remove the rate limit:", err)
+	}
+	_ = f.ChangeState("review")
+	if err = f.ChangeState("ready"); err != nil {
+		t.Error("limit removed: unexpected error:", err)
+	}
+}
+
+func TestEntryGuard(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{fsm.InitState, "state2"},
+		fsm.STPair{"state1", "state2"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	calls := []string{}
+	st.AddGlobalGuard(func(_ *fsm.FSM, _, to string) error {
+		calls = append(calls, "global: "+to)
+		return nil
+	})
+	err = st.AddEntryGuard("state2", func(f *fsm.FSM) error {
+		calls = append(calls, "entry: "+f.CurrentState())
+		if f.CurrentState() == fsm.InitState {
+			return errors.New("state1 must come first")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.AddEntryGuard("nonesuch", func(_ *fsm.FSM) error { return nil })
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`))
+
+	var u underlying
+	u.allowChange = true
+	f := fsm.New(st, &u)
+
+	err = f.ChangeState("state2")
+	testhelper.CheckExpErrWithID(t, "forbidden", err,
+		testhelper.MkExpErr("is forbidden", "state1 must come first"))
+	testhelper.DiffBool(t, "forbidden", "TransitionAllowed called",
+		u.transitionAllowedCalled, false)
+
+	for _, s := range []string{"state1", "state2"} {
+		if err = f.ChangeState(s); err != nil {
+			t.Error("unexpected error:", err)
+		}
+	}
+	testhelper.DiffStringSlice(t, "allowed", "guard calls", calls,
+		[]string{
+			"global: state2", "entry: init",
+			"global: state1",
+			"global: state2", "entry: state1",
+		})
+}
+
+func TestMaxTransitions(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	unlimited := fsm.New(st, nil)
+	testhelper.DiffInt(t, "unlimited", "remaining",
+		unlimited.TransitionsRemaining(), -1)
+
+	f := fsm.New(st, nil, fsm.WithMaxTransitions(3))
+	testhelper.DiffInt(t, "new", "remaining", f.TransitionsRemaining(), 3)
+
+	_ = f.ChangeState("B")
+	testhelper.DiffInt(t, "failed change", "remaining",
+		f.TransitionsRemaining(), 3)
+
+	for _, s := range []string{"A", "B", "A"} {
+		if err := f.ChangeState(s); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	testhelper.DiffInt(t, "used up", "remaining", f.TransitionsRemaining(), 0)
+	valid, reason := f.WhyNot("B")
+	testhelper.DiffBool(t, "used up", "WhyNot valid", valid, false)
+	testhelper.DiffString(t, "used up", "WhyNot reason", reason,
+		"the limit of 3 changes of state has been reached")
+	testhelper.DiffStringSlice(t, "used up", "next states allowed",
+		f.NextStatesAllowed(), []string{})
+	testhelper.DiffBool(t, "used up", "stuck", f.IsStuck(), true)
+	var tbe fsm.TransitionBudgetExceeded
+	if !errors.As(f.NextStateStatus()["B"], &tbe) {
+		t.Errorf("used up: expected a TransitionBudgetExceeded status,"+
+			" got: %v", f.NextStateStatus()["B"])
+	}
+
+	err = f.ChangeState("nonesuch")
+	testhelper.CheckExpErrWithID(t, "exceeded", err,
+		testhelper.MkExpErr(`FSM: "testFSM": Cannot change from "A"`,
+			"the limit of 3 changes of state has been reached"))
+	if !errors.As(err, &tbe) {
+		t.Errorf("exceeded: expected a TransitionBudgetExceeded error, got: %v",
+			err)
+	}
+
+	err = f.RunToTerminal(0, func(_ string, next []string) (string, bool) {
+		return next[0], true
+	})
+	testhelper.CheckExpErrWithID(t, "RunToTerminal", err,
+		testhelper.MkExpErr(`FSM: "testFSM": Cannot change from "A":`,
+			"the limit of 3 changes of state has been reached"))
+	if !errors.As(err, &tbe) {
+		t.Errorf("RunToTerminal: expected a TransitionBudgetExceeded error,"+
+			" got: %v", err)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "Released"},
+		fsm.STPair{fsm.InitState, "Rejected"},
+		fsm.STPair{fsm.InitState, "Abandoned"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	if err = st.ClassifyTerminal("Released", true); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	if err = st.ClassifyTerminal("Rejected", false); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.ClassifyTerminal(fsm.InitState, true)
+	testhelper.CheckExpErrWithID(t, "not terminal", err,
+		testhelper.MkExpErr(`testFSM: state: "init" is not a terminal state`))
+
+	testCases := []struct {
+		testhelper.ID
+		state         string
+		expSuccess    bool
+		expTerminal   bool
+		expClassified bool
+	}{
+		{
+			ID:    testhelper.MkID("not terminal"),
+			state: fsm.InitState,
+		},
+		{
+			ID:            testhelper.MkID("success"),
+			state:         "Released",
+			expSuccess:    true,
+			expTerminal:   true,
+			expClassified: true,
+		},
+		{
+			ID:            testhelper.MkID("failure"),
+			state:         "Rejected",
+			expTerminal:   true,
+			expClassified: true,
+		},
+		{
+			ID:          testhelper.MkID("unclassified"),
+			state:       "Abandoned",
+			expTerminal: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil)
+		if tc.state != fsm.InitState {
+			if err := f.ChangeState(tc.state); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		success, isTerminal, classified := f.Outcome()
+		testhelper.DiffBool(t, tc.IDStr(), "success", success, tc.expSuccess)
+		testhelper.DiffBool(t, tc.IDStr(), "terminal",
+			isTerminal, tc.expTerminal)
+		testhelper.DiffBool(t, tc.IDStr(), "classified",
+			classified, tc.expClassified)
+	}
+}
+
+// panickyUnderlying is an Underlying which panics in its check of any
+// change to the checkPanic state and on any transition into the
+// onTransPanic state
+type panickyUnderlying struct {
+	checkPanic   string
+	onTransPanic string
+}
+
+// (u panickyUnderlying)TransitionAllowed ...
+func (u panickyUnderlying) TransitionAllowed(_ *fsm.FSM, newState string,
+) error {
+	if newState == u.checkPanic {
+		panic("check of " + newState)
+	}
+	return nil
+}
+
+// (u panickyUnderlying)OnTransition ...
+func (u panickyUnderlying) OnTransition(f *fsm.FSM) {
+	if f.CurrentState() == u.onTransPanic {
+		panic("entering " + u.onTransPanic)
+	}
+}
+
+// (u panickyUnderlying)SetFSM ...
+func (u panickyUnderlying) SetFSM(_ *fsm.FSM) {}
+
+// optPanickyUnderlying is an Underlying which implements every optional
+// interface and panics in the method named by panicIn. Its check refuses
+// every change if refuse is set.
+type optPanickyUnderlying struct {
+	panicIn string
+	refuse  bool
+}
+
+// maybePanic panics with the name of the method if it is the panicIn method
+func (u optPanickyUnderlying) maybePanic(method string) {
+	if method == u.panicIn {
+		panic(method)
+	}
+}
+
+// (u optPanickyUnderlying)TransitionAllowed ...
+func (u optPanickyUnderlying) TransitionAllowed(_ *fsm.FSM, _ string) error {
+	u.maybePanic("TransitionAllowed")
+	if u.refuse {
+		return errors.New("refused")
+	}
+	return nil
+}
+
+// (u optPanickyUnderlying)OnTransition ...
+func (u optPanickyUnderlying) OnTransition(_ *fsm.FSM) {
+	u.maybePanic("OnTransition")
+}
+
+// (u optPanickyUnderlying)SetFSM ...
+func (u optPanickyUnderlying) SetFSM(_ *fsm.FSM) {
+	u.maybePanic("SetFSM")
+}
+
+// (u optPanickyUnderlying)Init ...
+func (u optPanickyUnderlying) Init(_ *fsm.FSM) error {
+	u.maybePanic("Init")
+	return nil
+}
+
+// (u optPanickyUnderlying)TransitionRejected ...
+func (u optPanickyUnderlying) TransitionRejected(_ *fsm.FSM, _ string,
+	_ error,
+) {
+	u.maybePanic("TransitionRejected")
+}
+
+// (u optPanickyUnderlying)IsTerminalOverride ...
+func (u optPanickyUnderlying) IsTerminalOverride(_ *fsm.FSM, _ string,
+) (bool, bool) {
+	u.maybePanic("IsTerminalOverride")
+	return false, false
+}
+
+// (u optPanickyUnderlying)Redirect ...
+func (u optPanickyUnderlying) Redirect(_ *fsm.FSM, _ string) (string, bool) {
+	u.maybePanic("Redirect")
+	return "", false
+}
+
+// (u optPanickyUnderlying)OnRevisit ...
+func (u optPanickyUnderlying) OnRevisit(_ *fsm.FSM, _ string, _ int) {
+	u.maybePanic("OnRevisit")
+}
+
+// (u optPanickyUnderlying)NextEvent ...
+func (u optPanickyUnderlying) NextEvent(_ *fsm.FSM) (string, bool) {
+	u.maybePanic("NextEvent")
+	return "", false
+}
+
+func TestWithRecoverOptional(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", fsm.InitState})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	changeTo := func(states ...string) func(f *fsm.FSM) error {
+		return func(f *fsm.FSM) error {
+			for _, s := range states {
+				if err := f.ChangeState(s); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		u        optPanickyUnderlying
+		act      func(f *fsm.FSM) error
+		expState string
+	}{
+		{
+			ID:       testhelper.MkID("SetFSM panics"),
+			u:        optPanickyUnderlying{panicIn: "SetFSM"},
+			expState: fsm.InitState,
+		},
+		{
+			ID: testhelper.MkID("Init panics"),
+			u:  optPanickyUnderlying{panicIn: "Init"},
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": the Underlying failed to initialise:` +
+					" it panicked: Init"),
+		},
+		{
+			ID:       testhelper.MkID("Redirect panics - not redirected"),
+			u:        optPanickyUnderlying{panicIn: "Redirect"},
+			act:      changeTo("A"),
+			expState: "A",
+		},
+		{
+			ID: testhelper.MkID("TransitionRejected panics - still refused"),
+			u: optPanickyUnderlying{
+				panicIn: "TransitionRejected",
+				refuse:  true,
+			},
+			act:      changeTo("A"),
+			expState: fsm.InitState,
+			ExpErr:   testhelper.MkExpErr("is forbidden: refused"),
+		},
+		{
+			ID: testhelper.MkID("IsTerminalOverride panics - no opinion"),
+			u:  optPanickyUnderlying{panicIn: "IsTerminalOverride"},
+			act: func(f *fsm.FSM) error {
+				if f.IsInTerminalState() {
+					return errors.New("unexpectedly terminal")
+				}
+				return nil
+			},
+			expState: fsm.InitState,
+		},
+		{
+			ID:       testhelper.MkID("OnRevisit panics"),
+			u:        optPanickyUnderlying{panicIn: "OnRevisit"},
+			act:      changeTo("A", fsm.InitState, "A"),
+			expState: "A",
+		},
+		{
+			ID:       testhelper.MkID("NextEvent panics - no event"),
+			u:        optPanickyUnderlying{panicIn: "NextEvent"},
+			act:      changeTo("A"),
+			expState: "A",
+		},
+	}
+
+	for _, tc := range testCases {
+		var recovered any
+		f, err := fsm.NewErr(st, tc.u,
+			fsm.WithHistory(0),
+			fsm.WithRecover(func(r any) { recovered = r }))
+		if err == nil && tc.act != nil {
+			err = tc.act(f)
+		}
+		testhelper.CheckExpErr(t, err, tc)
+		if err := testhelper.DiffVals(recovered, tc.u.panicIn); err != nil {
+			t.Log(tc.IDStr())
+			t.Errorf("\t: recovered value: %s", err)
+		}
+		if f != nil {
+			testhelper.DiffString(t, tc.IDStr(), "current state",
+				f.CurrentState(), tc.expState)
+		}
+	}
+}
+
+func TestWithRecover(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		newState       string
+		expState       string
+		expRecoveredOK bool
+		expRecovered   any
+	}{
+		{
+			ID:             testhelper.MkID("check panics"),
+			newState:       "A",
+			expState:       fsm.InitState,
+			expRecoveredOK: true,
+			expRecovered:   "check of A",
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": The change from "init" to "A" is forbidden:` +
+					` the check panicked: check of A`),
+		},
+		{
+			ID:             testhelper.MkID("OnTransition panics"),
+			newState:       "B",
+			expState:       "B",
+			expRecoveredOK: true,
+			expRecovered:   "entering B",
+		},
+	}
+
+	for _, tc := range testCases {
+		var recovered any
+		recoveredOK := false
+		f := fsm.New(st,
+			panickyUnderlying{checkPanic: "A", onTransPanic: "B"},
+			fsm.WithRecover(func(r any) {
+				recoveredOK = true
+				recovered = r
+			}))
+
+		err := f.ChangeState(tc.newState)
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffString(t, tc.IDStr(), "current state",
+			f.CurrentState(), tc.expState)
+		testhelper.DiffBool(t, tc.IDStr(), "recovered",
+			recoveredOK, tc.expRecoveredOK)
+		if err := testhelper.DiffVals(recovered, tc.expRecovered); err != nil {
+			t.Log(tc.IDStr())
+			t.Errorf("\t: recovered value: %s", err)
+		}
+	}
+}
+
+func TestAutoAdvance(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "received"},
+		fsm.STPair{"received", "logged"},
+		fsm.STPair{"logged", "queued"},
+		fsm.STPair{"queued", "done"},
+		fsm.STPair{"queued", "received"},
+		fsm.STPair{fsm.InitState, "loop"},
+		fsm.STPair{"loop", "loop"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	err = st.SetAutoAdvance("queued")
+	testhelper.CheckExpErrWithID(t, "several next states", err,
+		testhelper.MkExpErr(
+			`testFSM: state: "queued" does not have exactly one next state`))
+	err = st.SetAutoAdvance("loop")
+	testhelper.CheckExpErrWithID(t, "self loop", err,
+		testhelper.MkExpErr(
+			`testFSM: state: "loop" would auto-advance back to itself`))
+	err = st.SetAutoAdvance("nonesuch")
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`))
+
+	for _, s := range []string{"received", "logged"} {
+		if err := st.SetAutoAdvance(s); err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+	}
+
+	blocked := true
+	err = st.AddEntryGuard("queued", func(_ *fsm.FSM) error {
+		if blocked {
+			return errors.New("the queue is full")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	f := fsm.New(st, nil, fsm.WithHistory(0))
+	err = f.ChangeState("received")
+	testhelper.CheckExpErrWithID(t, "blocked", err,
+		testhelper.MkExpErr(
+			`FSM: "testFSM": The change from "logged" to "queued" is forbidden:`+
+				` the queue is full`))
+	testhelper.DiffString(t, "blocked", "current state",
+		f.CurrentState(), "logged")
+
+	blocked = false
+	f = fsm.New(st, nil, fsm.WithHistory(0))
+	if err = f.ChangeState("received"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	testhelper.DiffString(t, "advanced", "current state",
+		f.CurrentState(), "queued")
+	testhelper.DiffSlice(t, "advanced", "history", f.History(),
+		[]fsm.Transition{
+			{From: fsm.InitState, To: "received"},
+			{From: "received", To: "logged"},
+			{From: "logged", To: "queued"},
+		})
+}
+
+func TestSuccessorResolver(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "core"},
+		fsm.STPair{fsm.InitState, "plugin"},
+		fsm.STPair{"core", "done"},
+		fsm.STPair{"plugin", "done"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	plugins := []string{}
+	err = st.SetSuccessorResolver("core", func(_ *fsm.FSM) []string {
+		return plugins
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.SetSuccessorResolver("nonesuch", nil)
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testFSM: state: "nonesuch" does not exist`))
+
+	f := fsm.New(st, nil)
+	if err = f.ChangeState("core"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	testhelper.DiffStringSlice(t, "no plugins", "next states",
+		f.NextStates(), []string{"done"})
+	err = f.ChangeState("plugin")
+	testhelper.CheckExpErrWithID(t, "no plugins", err,
+		testhelper.MkExpErr(
+			`FSM: "testFSM": There is no valid transition from "core" to "plugin"`))
+
+	plugins = []string{"plugin", "nonesuch"}
+	testhelper.DiffStringSlice(t, "with plugins", "next states",
+		f.NextStates(), []string{"done", "plugin"})
+	testhelper.DiffStringSlice(t, "with plugins", "next states allowed",
+		f.NextStatesAllowed(), []string{"done", "plugin"})
+	if err = f.ChangeState("plugin"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	testhelper.DiffString(t, "with plugins", "current state",
+		f.CurrentState(), "plugin")
+}
+
+// spanKey is the type of the context key used by the recordingTracer
+type spanKey struct{}
+
+// recordingTracer is a Tracer which records the start and end of each
+// change of state
+type recordingTracer struct {
+	records []string
+}
+
+// (rt *recordingTracer)StartTransition ...
+func (rt *recordingTracer) StartTransition(ctx context.Context, from, to string,
+) (context.Context, func(err error)) {
+	span := from + " -> " + to
+	rt.records = append(rt.records, "start: "+span)
+	return context.WithValue(ctx, spanKey{}, span),
+		func(err error) {
+			rt.records = append(rt.records,
+				fmt.Sprintf("end: %s: %v", span, err))
+		}
+}
+
+func TestChangeStateCtx(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	var spans []any
+	err = st.AddEntryGuard("B", func(f *fsm.FSM) error {
+		spans = append(spans, f.Context().Value(spanKey{}))
+		return errors.New("B is not ready")
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var rt recordingTracer
+	f := fsm.New(st, nil, fsm.WithTracer(&rt))
+	if err = f.ChangeStateCtx(context.Background(), "A"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	_ = f.ChangeStateCtx(context.Background(), "B")
+	_ = f.ChangeState("B")
+
+	testhelper.DiffStringSlice(t, "traced", "records", rt.records,
+		[]string{
+			"start: init -> A",
+			"end: init -> A: <nil>",
+			"start: A -> B",
+			`end: A -> B: FSM: "testFSM": The change from "A" to "B"` +
+				" is forbidden: B is not ready",
+		})
+	if err := testhelper.DiffVals(spans, []any{"A -> B", nil}); err != nil {
+		t.Errorf("unexpected spans seen by the guard: %s", err)
+	}
+
+	f = fsm.New(st, nil)
+	if err = f.ChangeStateCtx(context.Background(), "A"); err != nil {
+		t.Error("unexpected error without a tracer:", err)
+	}
+}
+
+func TestRandomWalk(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "A"},
+		fsm.STPair{"A", "done"},
+		fsm.STPair{"B", "done"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	for seed := int64(0); seed < 20; seed++ {
+		id := fmt.Sprintf("seed: %d", seed)
+
+		f := fsm.New(st, nil)
+		path := f.RandomWalk(rand.New(rand.NewSource(seed)), 0)
+		testhelper.DiffString(t, id, "first state", path[0], fsm.InitState)
+		testhelper.DiffString(t, id, "last state",
+			path[len(path)-1], f.CurrentState())
+		testhelper.DiffBool(t, id, "terminal", f.IsInTerminalState(), true)
+		for i := 1; i < len(path); i++ {
+			succ, _ := st.Successors(path[i-1])
+			if _, ok := succ[path[i]]; !ok {
+				t.Log(id)
+				t.Errorf("\t: bad step in the path: %q -> %q",
+					path[i-1], path[i])
+			}
+		}
+
+		f = fsm.New(st, nil)
+		testhelper.DiffStringSlice(t, id, "repeated walk",
+			f.RandomWalk(rand.New(rand.NewSource(seed)), 0), path)
+	}
+
+	st, err = fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	f := fsm.New(st, nil)
+	testhelper.DiffStringSlice(t, "limited", "path",
+		f.RandomWalk(rand.New(rand.NewSource(1)), 4),
+		[]string{fsm.InitState, "A", "B", "A", "B"})
+}
+
+func TestCameVia(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{"A", "A"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		path     []string
+		from, to string
+		expVia   bool
+	}{
+		{
+			ID:   testhelper.MkID("no changes"),
+			from: fsm.InitState,
+			to:   fsm.InitState,
+		},
+		{
+			ID:     testhelper.MkID("direct"),
+			path:   []string{"B"},
+			from:   fsm.InitState,
+			to:     "B",
+			expVia: true,
+		},
+		{
+			ID:   testhelper.MkID("other route"),
+			path: []string{"A", "B"},
+			from: fsm.InitState,
+			to:   "B",
+		},
+		{
+			ID:     testhelper.MkID("self loop"),
+			path:   []string{"A", "A"},
+			from:   "A",
+			to:     "A",
+			expVia: true,
+		},
+		{
+			ID:   testhelper.MkID("unknown state"),
+			path: []string{"A"},
+			from: "nonesuch",
+			to:   "A",
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil)
+		for _, s := range tc.path {
+			if err := f.ChangeState(s); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		testhelper.DiffBool(t, tc.IDStr(), "came via",
+			f.CameVia(tc.from, tc.to), tc.expVia)
+	}
+}
+
+// initUnderlying is an Underlying which checks in its Init method that the
+// StateTrans has the required state
+type initUnderlying struct {
+	underlying
+	required string
+}
+
+// (u *initUnderlying)Init ...
+func (u *initUnderlying) Init(f *fsm.FSM) error {
+	if u.setFSMCallCount == 0 {
+		return errors.New("SetFSM was not called first")
+	}
+	if _, err := f.StepsTo(u.required); err != nil {
+		return fmt.Errorf("the state %q cannot be reached", u.required)
+	}
+	return nil
+}
+
+func TestNewErr(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "approved"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	st.TrackInstances()
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		st  *fsm.StateTrans
+		und fsm.Underlying
+	}{
+		{
+			ID:  testhelper.MkID("no Init method"),
+			st:  st,
+			und: &underlying{},
+		},
+		{
+			ID:  testhelper.MkID("Init succeeds"),
+			st:  st,
+			und: &initUnderlying{required: "approved"},
+		},
+		{
+			ID:  testhelper.MkID("Init fails"),
+			st:  st,
+			und: &initUnderlying{required: "rejected"},
+			ExpErr: testhelper.MkExpErr(`FSM: "testFSM":` +
+				` the Underlying failed to initialise:` +
+				` the state "rejected" cannot be reached`),
+		},
+		{
+			ID:     testhelper.MkID("nil StateTrans"),
+			und:    &underlying{},
+			ExpErr: testhelper.MkExpErr("FSM: the StateTrans is nil"),
+		},
+	}
+
+	for _, tc := range testCases {
+		f, err := fsm.NewErr(tc.st, tc.und)
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffBool(t, tc.IDStr(), "FSM created", f != nil, err == nil)
+		testhelper.DiffBool(t, tc.IDStr(), "New gives an FSM",
+			fsm.New(tc.st, tc.und) != nil, err == nil)
+	}
+	testhelper.DiffInt(t, "after all tests", "instances",
+		len(st.Instances()), 4)
+}
+
+func TestUnknownStateSuggestions(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "UnderReview"},
+		fsm.STPair{"UnderReview", "Rejected"},
+		fsm.STPair{"UnderReview", "Released"},
+		fsm.STPair{"UnderReview", "Reopened"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		newState       string
+		expSuggestions []string
+	}{
+		{
+			ID:             testhelper.MkID("transposed letters"),
+			newState:       "UnderReveiw",
+			expSuggestions: []string{"UnderReview"},
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": "UnderReveiw" is not a known state` +
+					" (did you mean: UnderReview?)"),
+		},
+		{
+			ID:             testhelper.MkID("case and a missing letter"),
+			newState:       "rejectd",
+			expSuggestions: []string{"Rejected"},
+			ExpErr: testhelper.MkExpErr(
+				`"rejectd" is not a known state (did you mean: Rejected?)`),
+		},
+		{
+			ID:             testhelper.MkID("too short to match"),
+			newState:       "Re-ed",
+			expSuggestions: []string{},
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": "Re-ed" is not a known state`),
+		},
+		{
+			ID:             testhelper.MkID("closest first"),
+			newState:       "Rejeased",
+			expSuggestions: []string{"Released", "Rejected"},
+			ExpErr: testhelper.MkExpErr(
+				"(did you mean: Released, Rejected?)"),
+		},
+		{
+			ID:             testhelper.MkID("nothing close"),
+			newState:       "nonesuch",
+			expSuggestions: []string{},
+			ExpErr: testhelper.MkExpErr(
+				`FSM: "testFSM": "nonesuch" is not a known state`),
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(st, nil)
+		err := f.ChangeState(tc.newState)
+		testhelper.CheckExpErr(t, err, tc)
+
+		var us fsm.UnknownState
+		if !errors.As(err, &us) {
+			t.Log(tc.IDStr())
+			t.Errorf("\t: expected an UnknownState error, got: %T", err)
+			continue
+		}
+		testhelper.DiffStringSlice(t, tc.IDStr(), "suggestions",
+			us.Suggestions(), tc.expSuggestions)
+		testhelper.DiffBool(t, tc.IDStr(), "comparable",
+			err == error(us), true)
+	}
+}
@@ -0,0 +1,24 @@
+package fsm
+
+// Machine is an interface covering the commonly used methods of an FSM. It
+// can be used in place of a *FSM by code which only needs to query or
+// change the state so that an alternative implementation, such as a mock,
+// can be supplied in tests.
+type Machine interface {
+	// Name returns the name of the Finite State Machine
+	Name() string
+	// CurrentState returns the name of the current state
+	CurrentState() string
+	// PriorState returns the name of the prior state
+	PriorState() string
+	// IsInInitialState returns true if the machine is in the initial state
+	IsInInitialState() bool
+	// IsInTerminalState returns true if the machine is in a terminal state
+	IsInTerminalState() bool
+	// NextStates returns a sorted slice of the names of the valid next
+	// states
+	NextStates() []string
+	// ChangeState changes the current state to the new state, returning a
+	// non-nil error if the change is not valid or not allowed
+	ChangeState(newState string) error
+}
@@ -1,18 +1,42 @@
 package fsm
 
+import (
+	"sort"
+	"time"
+)
+
 // state represents a state in a Finite State Machine. A terminal state is one
 // with an empty nextState map
 type state struct {
-	name      string
-	desc      string
-	nextState map[string]*state
+	name       string
+	desc       string
+	localeDesc map[string]string
+	group      string
+	meta       map[string]string
+	nextState  map[string]*state
+
+	mustHaveVisited []string
+	deprecatedNext  map[string]string
+	forbiddenNext   map[string]bool
+	reopenTo        map[string]*state
+	minInterval     map[string]time.Duration
+	entryGuards     []func(f *FSM) error
+	pureEntryGuards []func(f *FSM) error
+	cost            map[string]int
+	resolver        func(f *FSM) []string
+	events          map[string]string
+
+	classified  bool
+	success     bool
+	autoAdvance bool
 }
 
-// newState returns a newly constructed state
-func newState(name string) *state {
+// newState returns a newly constructed state. The map of next states is
+// created with space for the given number of next states.
+func newState(name string, nextCount int) *state {
 	return &state{
 		name:      name,
-		nextState: make(map[string]*state),
+		nextState: make(map[string]*state, nextCount),
 	}
 }
 
@@ -22,6 +46,32 @@ func (s state) isTerminal() bool {
 	return len(s.nextState) == 0
 }
 
+// soleNext returns the only next state or nil if there is not exactly one
+func (s state) soleNext() *state {
+	if len(s.nextState) != 1 {
+		return nil
+	}
+	for _, ns := range s.nextState {
+		return ns
+	}
+	return nil
+}
+
+// nextNames returns the names of the next states in sorted order
+func (s state) nextNames() []string {
+	return sortedNames(s.nextState)
+}
+
+// sortedNames returns the keys of the map of states in sorted order
+func sortedNames(states map[string]*state) []string {
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // (s state)String returns a string describing the state
 func (s state) String() string {
 	str := s.name
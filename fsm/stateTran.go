@@ -2,9 +2,9 @@ package fsm
 
 import (
 	"fmt"
-	"io"
 	"sort"
 	"strings"
+	"time"
 )
 
 const InitState = "init"
@@ -13,6 +13,22 @@ const InitState = "init"
 type StateTrans struct {
 	name   string
 	states map[string]*state
+
+	foldCase bool
+	folded   map[string]string
+	aliases  map[string]string
+
+	allowed map[string]bool
+
+	nextCountHint map[string]int
+
+	inst *instances
+
+	globalGuards     []func(f *FSM, from, to string) error
+	pureGlobalGuards []func(f *FSM, from, to string) error
+
+	version string
+	extra   map[string]any
 }
 
 // StateDesc records a state name and an associated description
@@ -27,6 +43,17 @@ type STPair struct {
 	From, To string
 }
 
+// sortSTPairs sorts the slice of STPairs by the From state and then by the
+// To state
+func sortSTPairs(pairs []STPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].From != pairs[j].From {
+			return pairs[i].From < pairs[j].From
+		}
+		return pairs[i].To < pairs[j].To
+	})
+}
+
 // NewStateTrans creates a new set of State transitions. The allowed
 // transitions must be set at creation time by passing STPair's. If setting
 // the transitions from the passed slice returns an error then this function
@@ -43,16 +70,78 @@ type STPair struct {
 // The name has no semantic meaning and is only used for documentation
 // purposes.
 func NewStateTrans(name string, transitions ...STPair) (*StateTrans, error) {
-	st := &StateTrans{
-		name:   name,
-		states: make(map[string]*state),
+	st := mkStateTrans(name, 0, nil)
+
+	err := st.set(transitions...)
+	if err != nil {
+		return nil, err
 	}
 
-	is := newState(InitState)
-	is.desc = "the initial state"
-	st.states[InitState] = is
+	return st, nil
+}
+
+// NewStateTransFoldCase creates a new set of State transitions in the same
+// way as NewStateTrans except that state names are matched without regard
+// to case. This applies to ChangeState, HasState and SetStateDesc which will
+// all accept any casing of a state name and resolve it to the name as given
+// in the transitions, the canonical name. The canonical name is the one
+// that is reported, for instance by CurrentState or PrintDot.
+//
+// The transitions must always use the same casing for a state. An error is
+// returned if two names that differ only in case are used since it would be
+// ambiguous which was the canonical name.
+func NewStateTransFoldCase(name string, transitions ...STPair,
+) (*StateTrans, error) {
+	st := mkStateTrans(name, 0, nil)
+	st.foldCase = true
+	st.folded = map[string]string{strings.ToLower(InitState): InitState}
+
+	err := st.set(transitions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// NewStateTransClosed creates a new set of State transitions in the same
+// way as NewStateTrans except that only the states named in allowedStates
+// (and the InitState) may be used. An error is returned if any transition
+// refers to any other state. This will catch a mistyped 'to' state which
+// NewStateTrans would silently create as a new state.
+func NewStateTransClosed(name string, allowedStates []string,
+	transitions ...STPair,
+) (*StateTrans, error) {
+	st := mkStateTrans(name, 0, nil)
+	st.allowed = map[string]bool{InitState: true}
+	for _, s := range allowedStates {
+		st.allowed[s] = true
+	}
+
+	err := st.set(transitions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// NewStateTransCap creates a new set of State transitions in the same way
+// as NewStateTrans except that space is reserved in advance for the given
+// number of states and for the next states of each state. This reduces the
+// number of allocations needed to construct a large StateTrans; the
+// behaviour is otherwise identical.
+func NewStateTransCap(name string, capacity int, transitions ...STPair,
+) (*StateTrans, error) {
+	nextCount := make(map[string]int)
+	for _, stp := range transitions {
+		nextCount[stp.From]++
+	}
+
+	st := mkStateTrans(name, capacity, nextCount)
 
 	err := st.set(transitions...)
+	st.nextCountHint = nil
 	if err != nil {
 		return nil, err
 	}
@@ -60,19 +149,170 @@ func NewStateTrans(name string, transitions ...STPair) (*StateTrans, error) {
 	return st, nil
 }
 
+// NewStateTransAuto creates a new set of State transitions in the same way
+// as NewStateTrans except that the transitions may be given in any order.
+// Every state named in any of the transitions is created first and then
+// the transitions between them are added. This means that states which
+// cannot be reached from the InitState may be created (see
+// UnreachableStates); IsWeaklyConnected can be used to check for parts of
+// the graph that are completely separate.
+func NewStateTransAuto(name string, transitions ...STPair,
+) (*StateTrans, error) {
+	st := mkStateTrans(name, 0, nil)
+
+	for _, stp := range transitions {
+		if _, ok := st.states[stp.From]; !ok {
+			st.addState(stp.From)
+		}
+	}
+
+	err := st.set(transitions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// mkStateTrans returns a new StateTrans with just the InitState. Space is
+// reserved for the given number of states and the nextCount map (which may
+// be nil) gives the number of next states to reserve space for as each
+// state is created.
+func mkStateTrans(name string, capacity int, nextCount map[string]int,
+) *StateTrans {
+	st := &StateTrans{
+		name:          name,
+		states:        make(map[string]*state, capacity),
+		nextCountHint: nextCount,
+	}
+
+	is := newState(InitState, nextCount[InitState])
+	is.desc = "the initial state"
+	st.states[InitState] = is
+
+	return st
+}
+
+// canonicalName returns the name of the state as it is stored in the
+// StateTrans. This is the name itself unless the StateTrans matches names
+// regardless of case in which case it will be the name as originally
+// given, or the name is an alias (see AddAlias) in which case it will be
+// the name of the aliased state. If there is no matching state the name is
+// returned unchanged.
+func (st StateTrans) canonicalName(name string) string {
+	if st.foldCase {
+		if cName, ok := st.folded[strings.ToLower(name)]; ok {
+			return cName
+		}
+	}
+	if cName, ok := st.aliases[st.aliasKey(name)]; ok {
+		return cName
+	}
+	return name
+}
+
+// aliasKey returns the key under which the alias is recorded. This is the
+// alias itself unless the StateTrans matches names regardless of case.
+func (st StateTrans) aliasKey(alias string) string {
+	if st.foldCase {
+		return strings.ToLower(alias)
+	}
+	return alias
+}
+
+// AddAlias adds an alternative name for the canonical state. The alias
+// can then be used in place of the state name in, for instance,
+// ChangeState, HasState and SetStateDesc. Names reported by the
+// StateTrans or an FSM, such as by CurrentState, are always the canonical
+// names. It will return an error if the canonical state does not exist or
+// if the alias is already the name of a state or another alias.
+func (st *StateTrans) AddAlias(canonical, alias string) error {
+	s, err := st.getState(canonical)
+	if err != nil {
+		return err
+	}
+	if st.HasState(alias) {
+		return fmt.Errorf(
+			"%s: alias: %q clashes with an existing state or alias",
+			st.name, alias)
+	}
+
+	if st.aliases == nil {
+		st.aliases = make(map[string]string)
+	}
+	st.aliases[st.aliasKey(alias)] = s.name
+	return nil
+}
+
 // HasState return true if the StateTrans object contains a state with the
 // given name.
 func (st StateTrans) HasState(name string) bool {
-	_, ok := st.states[name]
+	_, ok := st.states[st.canonicalName(name)]
 	return ok
 }
 
+// getState returns the named state or an error if there is no such state
+func (st StateTrans) getState(name string) (*state, error) {
+	s, ok := st.states[st.canonicalName(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s: state: %q does not exist", st.name, name)
+	}
+	return s, nil
+}
+
+// getTransition returns the states at either end of the transition from the
+// 'from' state to the 'to' state or an error if either state or the
+// transition between them does not exist.
+func (st StateTrans) getTransition(from, to string,
+) (fs, ts *state, err error) {
+	fs, err = st.getState(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	ts, err = st.getState(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := fs.nextState[ts.name]; !ok {
+		return nil, nil, fmt.Errorf("%s: there is no transition from %q to %q",
+			st.name, fs.name, ts.name)
+	}
+	return fs, ts, nil
+}
+
+// checkCase returns an error if the StateTrans matches names regardless of
+// case and the name clashes with an existing state with different casing.
+func (st StateTrans) checkCase(name string) error {
+	if cName := st.canonicalName(name); cName != name {
+		return fmt.Errorf(
+			"%s: state: %q clashes with state %q (case is ignored)",
+			st.name, name, cName)
+	}
+	return nil
+}
+
 // add adds a new transition from one state in the FSM to another.
 //
 // The 'from' state must already exist in the FSM so the order of adding
 // changes is important. If the 'from' state doesn't exist an error will be
 // returned. This is to ensure that every state can be reached.
 func (st *StateTrans) add(from, to string) error {
+	for _, name := range []string{from, to} {
+		if err := st.checkCase(name); err != nil {
+			return err
+		}
+	}
+
+	if st.allowed != nil {
+		for _, name := range []string{from, to} {
+			if !st.allowed[name] {
+				return fmt.Errorf(
+					"%s: state: '%s' is not allowed. Add('%s', '%s') failed",
+					st.name, name, from, to)
+			}
+		}
+	}
+
 	fromState, ok := st.states[from]
 	if !ok {
 		return fmt.Errorf(
@@ -82,14 +322,24 @@ func (st *StateTrans) add(from, to string) error {
 
 	toState, ok := st.states[to]
 	if !ok {
-		toState = newState(to)
-		st.states[to] = toState
+		toState = st.addState(to)
 	}
 	fromState.nextState[toState.name] = toState
 
 	return nil
 }
 
+// addState creates a new state with the given name, records it in the
+// StateTrans and returns it. The state must not already exist.
+func (st *StateTrans) addState(name string) *state {
+	s := newState(name, st.nextCountHint[name])
+	st.states[name] = s
+	if st.foldCase {
+		st.folded[strings.ToLower(name)] = name
+	}
+	return s
+}
+
 // set sets the transitions from the slice of state transition pairs. The same
 // rules apply as for the add func about the existence of the 'From' state
 // before trying to switch to the 'To' state
@@ -108,110 +358,689 @@ func (st StateTrans) Name() string {
 	return st.name
 }
 
+// SetVersion sets the version of the StateTrans. The version is not used by
+// the package but it is recorded when the StateTrans is marshalled, as YAML
+// or as part of an FSM's JSON document, and restored when it is loaded. It
+// can then be compared with the version of the current StateTrans to see
+// if a persisted FSM needs to be migrated.
+func (st *StateTrans) SetVersion(v string) {
+	st.version = v
+}
+
+// Version returns the version of the StateTrans (see SetVersion). It is
+// empty if no version has been set.
+func (st StateTrans) Version() string {
+	return st.version
+}
+
 // StateCount returns a count of the number of states
 func (st StateTrans) StateCount() int {
 	return len(st.states)
 }
 
+// Successors returns a map from the name of each of the next states of the
+// named state to its description. The map is a copy and so may be freely
+// changed. An error is returned if the named state does not exist.
+func (st StateTrans) Successors(name string) (map[string]string, error) {
+	s, err := st.getState(name)
+	if err != nil {
+		return nil, err
+	}
+
+	succ := make(map[string]string, len(s.nextState))
+	for nextName, ns := range s.nextState {
+		succ[nextName] = ns.desc
+	}
+	return succ, nil
+}
+
+// AreAdjacent returns true if there is a transition from state a to state
+// b or from state b to state a. A state is adjacent to itself only if it
+// has a transition to itself. An error is returned if either of the named
+// states does not exist.
+func (st StateTrans) AreAdjacent(a, b string) (bool, error) {
+	sa, err := st.getState(a)
+	if err != nil {
+		return false, err
+	}
+	sb, err := st.getState(b)
+	if err != nil {
+		return false, err
+	}
+
+	_, aToB := sa.nextState[sb.name]
+	_, bToA := sb.nextState[sa.name]
+	return aToB || bToA, nil
+}
+
+// CanRestore checks that an FSM could have been left in the given current
+// state having come from the given prior state. This is so either if both
+// are the InitState (a newly created FSM) or if there is a transition, or
+// a reopen transition (see AddReopen), from the prior state to the current
+// state. It will return an error if either state does not exist or there
+// is no such transition. This can be used to check persisted FSM states
+// after the StateTrans has changed.
+func (st StateTrans) CanRestore(current, prior string) error {
+	_, _, err := st.restorePair(current, prior)
+	return err
+}
+
+// restorePair returns the current and prior states, as held by the
+// StateTrans, if an FSM could have been left in the current state having
+// come from the prior state (see CanRestore). Otherwise it returns an
+// error.
+func (st StateTrans) restorePair(current, prior string,
+) (cs, ps *state, err error) {
+	cs, err = st.getState(current)
+	if err != nil {
+		return nil, nil, err
+	}
+	ps, err = st.getState(prior)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cs.name == InitState && ps.name == InitState {
+		return cs, ps, nil
+	}
+	_, isNext := ps.nextState[cs.name]
+	_, isReopen := ps.reopenTo[cs.name]
+	if !isNext && !isReopen {
+		return nil, nil, fmt.Errorf("%s: there is no transition from %q to %q",
+			st.name, ps.name, cs.name)
+	}
+	return cs, ps, nil
+}
+
+// TransitionCount returns a count of the number of transitions between
+// states
+func (st StateTrans) TransitionCount() int {
+	count := 0
+	for _, s := range st.states {
+		count += len(s.nextState)
+	}
+	return count
+}
+
+// Summary returns a short, single line description of the StateTrans,
+// for instance:
+//
+//	lifecycle: 10 states, 14 transitions, 2 terminals
+//
+// If any states cannot be reached from the InitState (see
+// UnreachableStates) the number of them is added as ", 1 unreachable".
+func (st StateTrans) Summary() string {
+	_, terminal := st.GroupStates()
+
+	summary := fmt.Sprintf("%s: %s, %s, %s", st.name,
+		countOf(st.StateCount(), "state"),
+		countOf(st.TransitionCount(), "transition"),
+		countOf(len(terminal), "terminal"))
+	if unreachable := st.UnreachableStates(); len(unreachable) > 0 {
+		summary += fmt.Sprintf(", %d unreachable", len(unreachable))
+	}
+	return summary
+}
+
+// countOf returns the count followed by the noun, made plural if the count
+// is not 1
+func countOf(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+// OrderedStates returns the names of all the states in the canonical order
+// used wherever the states are written out: the InitState first and then
+// the remaining states sorted lexicographically. The ordering does not
+// depend on the order in which the transitions were added nor on map
+// iteration order so it can be relied upon in snapshot tests.
+func (st StateTrans) OrderedStates() []string {
+	names := make([]string, 0, len(st.states))
+	for name := range st.states {
+		if name != InitState {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return append([]string{InitState}, names...)
+}
+
+// GroupStates returns the names of the states split into two groups, those
+// that are not terminal and those that are. Each slice is sorted. The
+// InitState will appear in whichever group it belongs to.
+func (st StateTrans) GroupStates() (nonTerminal, terminal []string) {
+	nonTerminal = []string{}
+	terminal = []string{}
+
+	for name, s := range st.states {
+		if s.isTerminal() {
+			terminal = append(terminal, name)
+		} else {
+			nonTerminal = append(nonTerminal, name)
+		}
+	}
+	sort.Strings(nonTerminal)
+	sort.Strings(terminal)
+
+	return nonTerminal, terminal
+}
+
 // SetStateDesc sets the state description. It will return an error if the
 // named state does not exist.
 func (st *StateTrans) SetStateDesc(name, desc string) error {
-	s, ok := st.states[name]
-	if !ok {
-		return fmt.Errorf("%s: state: %q does not exist", st.name, name)
+	s, err := st.getState(name)
+	if err != nil {
+		return err
 	}
 
 	s.desc = desc
 	return nil
 }
 
-// SetDescriptions sets the state descriptions from the values given in the
-// slice of state descriptions. It will return an error if any
-// state does not exist and will set the error state on the StateTrans. It will
-// also return an error if the StateTrans already has its error state set.
-func (st *StateTrans) SetDescriptions(descriptions ...StateDesc) error {
-	for _, sd := range descriptions {
-		err := st.SetStateDesc(sd.Name, sd.Desc)
+// FindByDesc returns the sorted names of those states whose description
+// contains substr, ignoring case. States with no description are never
+// returned so an empty substr gives all the described states.
+func (st StateTrans) FindByDesc(substr string) []string {
+	substr = strings.ToLower(substr)
+	found := []string{}
+
+	for name, s := range st.states {
+		if s.desc == "" {
+			continue
+		}
+		if strings.Contains(strings.ToLower(s.desc), substr) {
+			found = append(found, name)
+		}
+	}
+	sort.Strings(found)
+
+	return found
+}
+
+// RequireVisited records that the FSM may only change to the 'to' state if
+// every one of the mustHaveVisited states appears in its history (the
+// InitState is always taken as having been visited). If not, ChangeState
+// will return a ForbiddenChange error naming the missing state. This
+// replaces any states previously required for the 'to' state. It will
+// return an error if any of the named states does not exist.
+//
+// Note that this relies on the history of the FSM so FSMs should be created
+// with the WithHistory option. Without it, or if the required state has
+// been discarded from a limited history, the change will be forbidden.
+func (st *StateTrans) RequireVisited(to string, mustHaveVisited ...string,
+) error {
+	s, err := st.getState(to)
+	if err != nil {
+		return err
+	}
+
+	required := make([]string, 0, len(mustHaveVisited))
+	for _, name := range mustHaveVisited {
+		rs, err := st.getState(name)
 		if err != nil {
 			return err
 		}
+		required = append(required, rs.name)
 	}
+
+	s.mustHaveVisited = required
 	return nil
 }
 
-// PrintDot prints the state transitions as a directed graph in the
-// graphviz DOT language. The output of this func can be interpreted by the
-// dot command (on Linux). To generate a png file from this you could write
-// the output to a file called, for instance, stateTrans.gv and then use the
-// following command to generate the png image and write it to a file called
-// graph.png
-//
-//	dot -Tpng -ograph.png stateTrans.gv
-//
-// This might be useful for generating documentation for your package.
-func (st StateTrans) PrintDot(w io.Writer) {
-	safeNames := make(map[string]string)
-	for stateName := range st.states {
-		safeNames[stateName] = strings.ReplaceAll(stateName, "\"", "\\\"")
+// DeprecateTransition marks the transition from the 'from' state to the
+// 'to' state as deprecated, recording the note which should explain why
+// and what should be used instead. The transition can still be made but
+// when it is the FSM's Logger, if it implements the DeprecationLogger
+// interface, is told. It will return an error if either of the named
+// states or the transition between them does not exist.
+func (st *StateTrans) DeprecateTransition(from, to, note string) error {
+	fs, ts, err := st.getTransition(from, to)
+	if err != nil {
+		return err
 	}
 
-	namesInOrder := make([]string, 0, len(st.states))
-	for name := range st.states {
-		namesInOrder = append(namesInOrder, name)
-	}
-	sort.Strings(namesInOrder)
-
-	fmt.Fprintln(w, "// A state transition graph for")
-	fmt.Fprintln(w, "//      ", st.name)
-	fmt.Fprintln(w, "digraph st {")
-
-	fmt.Fprintln(w, "    node [shape = doublecircle")
-	fmt.Fprintln(w, "          style=filled fillcolor=lightblue];")
-	fmt.Fprintf(w, "        \"%s\"", InitState)
-	fmt.Fprintln(w, ";")
-	fmt.Fprintln(w, "    node [shape = doublecircle")
-	fmt.Fprintln(w, "          style=filled fillcolor=grey85];")
-	sep := ""
-	fmt.Fprint(w, "        ")
-	for _, name := range namesInOrder {
-		s := st.states[name]
-		if s.isTerminal() {
-			fmt.Fprintf(w, "%s\"%s\"", sep, safeNames[name])
-			sep = ", "
+	if fs.deprecatedNext == nil {
+		fs.deprecatedNext = make(map[string]string)
+	}
+	fs.deprecatedNext[ts.name] = note
+	return nil
+}
+
+// DeprecatedTransitions returns the transitions which have been marked as
+// deprecated (see DeprecateTransition) sorted by the 'from' state and then
+// by the 'to' state.
+func (st StateTrans) DeprecatedTransitions() []STPair {
+	pairs := []STPair{}
+	for name, s := range st.states {
+		for to := range s.deprecatedNext {
+			pairs = append(pairs, STPair{From: name, To: to})
 		}
 	}
-	fmt.Fprintln(w, ";")
-	fmt.Fprintln(w, "    node [shape = circle style=solid];")
+	sortSTPairs(pairs)
 
-	fmt.Fprintln(w, "    { rank = same;")
-	fmt.Fprint(w, "        ")
-	for _, name := range namesInOrder {
-		s := st.states[name]
-		if s.isTerminal() {
-			fmt.Fprintf(w, "\"%s\" ", safeNames[name])
+	return pairs
+}
+
+// ForbidTransition blocks the transition from the 'from' state to the 'to'
+// state. Any attempt to make the change will fail with a ForbiddenChange
+// error without the Underlying being consulted. This applies to every FSM
+// using the StateTrans and so can be used to disable a problematic
+// transition temporarily, for instance while an incident is investigated;
+// AllowTransition will lift the block. It will return an error if either
+// of the named states or the transition between them does not exist.
+func (st *StateTrans) ForbidTransition(from, to string) error {
+	fs, ts, err := st.getTransition(from, to)
+	if err != nil {
+		return err
+	}
+
+	if fs.forbiddenNext == nil {
+		fs.forbiddenNext = make(map[string]bool)
+	}
+	fs.forbiddenNext[ts.name] = true
+	return nil
+}
+
+// AllowTransition lifts any block placed on the transition from the 'from'
+// state to the 'to' state by ForbidTransition. It will return an error if
+// either of the named states or the transition between them does not
+// exist.
+func (st *StateTrans) AllowTransition(from, to string) error {
+	fs, ts, err := st.getTransition(from, to)
+	if err != nil {
+		return err
+	}
+
+	delete(fs.forbiddenNext, ts.name)
+	return nil
+}
+
+// ForbiddenTransitions returns the transitions which have been blocked
+// (see ForbidTransition) sorted by the 'from' state and then by the 'to'
+// state.
+func (st StateTrans) ForbiddenTransitions() []STPair {
+	pairs := []STPair{}
+	for name, s := range st.states {
+		for to := range s.forbiddenNext {
+			pairs = append(pairs, STPair{From: name, To: to})
 		}
 	}
-	fmt.Fprintln(w, "}")
+	sortSTPairs(pairs)
+
+	return pairs
+}
+
+// SetRateLimit sets the minimum interval between changes of state from the
+// 'from' state to the 'to' state. If an FSM attempts the change again
+// before that time has passed, as measured by the FSM's Clock, ChangeState
+// will return a RateLimited error. This can be used to prevent an FSM
+// flapping between two states. A minInterval of zero or less removes the
+// limit. It will return an error if either of the named states or the
+// transition between them does not exist.
+func (st *StateTrans) SetRateLimit(from, to string,
+	minInterval time.Duration,
+) error {
+	fs, ts, err := st.getTransition(from, to)
+	if err != nil {
+		return err
+	}
+
+	if minInterval <= 0 {
+		delete(fs.minInterval, ts.name)
+		return nil
+	}
+	if fs.minInterval == nil {
+		fs.minInterval = make(map[string]time.Duration)
+	}
+	fs.minInterval[ts.name] = minInterval
+	return nil
+}
+
+// SetTransitionCost sets the cost of the transition from the 'from' state
+// to the 'to' state. This is used by CheapestPath; every transition has a
+// cost of 1 unless it is set otherwise. It will return an error if the cost
+// is negative or if either of the named states or the transition between
+// them does not exist.
+func (st *StateTrans) SetTransitionCost(from, to string, cost int) error {
+	fs, ts, err := st.getTransition(from, to)
+	if err != nil {
+		return err
+	}
+	if cost < 0 {
+		return fmt.Errorf("%s: the cost of the transition from %q to %q"+
+			" must not be negative: %d",
+			st.name, fs.name, ts.name, cost)
+	}
+
+	if fs.cost == nil {
+		fs.cost = make(map[string]int)
+	}
+	fs.cost[ts.name] = cost
+	return nil
+}
+
+// ClassifyTerminal records whether reaching the named terminal state means
+// that the FSM has succeeded or failed, for instance "Released" might be a
+// success and "Rejected" a failure. This is reported by FSM.Outcome and
+// PrintDot fills successful terminal states in green and failed ones in
+// red. It will return an error if the named state does not exist or is not
+// a terminal state.
+func (st *StateTrans) ClassifyTerminal(name string, success bool) error {
+	s, err := st.getState(name)
+	if err != nil {
+		return err
+	}
+	if !s.isTerminal() {
+		return fmt.Errorf("%s: state: %q is not a terminal state",
+			st.name, s.name)
+	}
+
+	s.classified = true
+	s.success = success
+	return nil
+}
+
+// AddReopen adds a reopen transition from the terminal 'from' state to the
+// 'to' state. A reopen transition can only be made through FSM.Reopen and
+// not through FSM.ChangeState. It is not reported as a next state, for
+// instance by NextStates or PrintDot, and the 'from' state remains a
+// terminal state. This allows, for example, a closed item to be
+// deliberately reopened without offering this as a normal change of
+// state. It will return an error if either state does not exist or if the
+// 'from' state is not a terminal state.
+func (st *StateTrans) AddReopen(from, to string) error {
+	fs, err := st.getState(from)
+	if err != nil {
+		return err
+	}
+	ts, err := st.getState(to)
+	if err != nil {
+		return err
+	}
+	if !fs.isTerminal() {
+		return fmt.Errorf("%s: state: %q is not a terminal state",
+			st.name, fs.name)
+	}
+
+	if fs.reopenTo == nil {
+		fs.reopenTo = make(map[string]*state)
+	}
+	fs.reopenTo[ts.name] = ts
+	return nil
+}
+
+// SetAutoAdvance marks the named state as a pass-through state. When an
+// FSM enters the state it will immediately try to change to the state's
+// only next state, with all the usual checks. It will return an error if
+// the state does not exist, if it does not have exactly one next state or
+// if following the pass-through states from it would lead back to it.
+func (st *StateTrans) SetAutoAdvance(name string) error {
+	s, err := st.getState(name)
+	if err != nil {
+		return err
+	}
+	next := s.soleNext()
+	if next == nil {
+		return fmt.Errorf("%s: state: %q does not have exactly one next state",
+			st.name, s.name)
+	}
+	for next != s && next.autoAdvance {
+		next = next.soleNext()
+	}
+	if next == s {
+		return fmt.Errorf("%s: state: %q would auto-advance back to itself",
+			st.name, s.name)
+	}
+
+	s.autoAdvance = true
+	return nil
+}
+
+// SetSuccessorResolver sets a function which will be called to find
+// additional valid next states of the named state at run time. Whenever an
+// FSM in the state needs its valid next states, for instance in
+// ChangeState or NextStates, the function is called with the FSM and the
+// states whose names it returns are treated as valid next states in
+// addition to those given when the StateTrans was created. Any names which
+// are not states of the StateTrans are ignored. This allows, for instance,
+// plugins to extend the allowed transitions without rebuilding a shared
+// StateTrans. The resolver should be quick and must not change the state
+// of the FSM. A nil function removes any existing resolver.
+//
+// Note that the resolved transitions are only known at run time and so
+// they are not considered by those methods that examine the StateTrans
+// alone, such as PrintDot, TransitionCount or the graph analyses, nor does
+// a resolver stop a state with no other next states from being terminal.
+// It will return an error if the named state does not exist.
+func (st *StateTrans) SetSuccessorResolver(name string,
+	fn func(f *FSM) []string,
+) error {
+	s, err := st.getState(name)
+	if err != nil {
+		return err
+	}
 
-	for _, name := range namesInOrder {
-		s := st.states[name]
-		nextNamesInOrder := make([]string, 0, len(s.nextState))
-		for _, ns := range s.nextState {
-			nextNamesInOrder = append(nextNamesInOrder, ns.name)
+	s.resolver = fn
+	return nil
+}
+
+// SetStateGroup sets the group of the state. States in the same group are
+// drawn together in a cluster by PrintDot. An empty group removes the
+// state from any group. It will return an error if the named state does
+// not exist.
+func (st *StateTrans) SetStateGroup(name, group string) error {
+	s, err := st.getState(name)
+	if err != nil {
+		return err
+	}
+
+	s.group = group
+	return nil
+}
+
+// StateGroup returns the group of the named state and true if it has been
+// given one, otherwise it returns an empty string and false.
+func (st StateTrans) StateGroup(name string) (string, bool) {
+	s, err := st.getState(name)
+	if err != nil || s.group == "" {
+		return "", false
+	}
+	return s.group, true
+}
+
+// SetStateMeta sets the metadata of the state, replacing any metadata
+// previously set. This can be used to attach any further information to a
+// state, for instance the name of an icon or the permission needed to
+// enter it. The map is copied. An empty map removes any metadata. It will
+// return an error if the named state does not exist.
+func (st *StateTrans) SetStateMeta(name string, meta map[string]string,
+) error {
+	s, err := st.getState(name)
+	if err != nil {
+		return err
+	}
+
+	s.meta = nil
+	if len(meta) > 0 {
+		s.meta = make(map[string]string, len(meta))
+		for k, v := range meta {
+			s.meta[k] = v
 		}
-		sort.Strings(nextNamesInOrder)
+	}
+	return nil
+}
+
+// StateMeta returns a copy of the metadata of the named state and true if
+// it has been given any, otherwise it returns a nil map and false.
+func (st StateTrans) StateMeta(name string) (map[string]string, bool) {
+	s, err := st.getState(name)
+	if err != nil || s.meta == nil {
+		return nil, false
+	}
+
+	meta := make(map[string]string, len(s.meta))
+	for k, v := range s.meta {
+		meta[k] = v
+	}
+	return meta, true
+}
 
-		for _, nextName := range nextNamesInOrder {
-			fmt.Fprintf(w, "    \"%s\" -> \"%s\"\n",
-				safeNames[name],
-				safeNames[nextName])
+// Groups returns a map from each group name to the sorted names of the
+// states in that group. States without a group are not included.
+func (st StateTrans) Groups() map[string][]string {
+	groups := map[string][]string{}
+	for name, s := range st.states {
+		if s.group != "" {
+			groups[s.group] = append(groups[s.group], name)
 		}
 	}
+	for _, names := range groups {
+		sort.Strings(names)
+	}
+	return groups
+}
 
-	fmt.Fprintln(w, "    fontsize=22")
+// AddGlobalGuard adds a function which will be called whenever any FSM
+// using this StateTrans attempts a valid change of state. It is passed the
+// FSM and the states being left and entered. If it returns a non-nil error
+// the change is forbidden and ChangeState returns a ForbiddenChange error
+// wrapping it. The global guards are called in the order they were added
+// and before the Underlying's TransitionAllowed function.
+//
+// A global guard is suitable for a rule which applies to every change of
+// state regardless of the states involved.
+func (st *StateTrans) AddGlobalGuard(fn func(f *FSM, from, to string) error) {
+	st.globalGuards = append(st.globalGuards, fn)
+}
 
-	fmt.Fprintf(w, "    label = \"\n%s\n\"\n",
-		strings.ReplaceAll(st.name, "\"", "\\\""))
+// AddEntryGuard adds a function which will be called whenever any FSM using
+// this StateTrans attempts a valid change of state into the named state. If
+// it returns a non-nil error the change is forbidden and ChangeState
+// returns a ForbiddenChange error wrapping it. The entry guards are called
+// in the order they were added, after any global guards and before the
+// Underlying's TransitionAllowed function. It will return an error if the
+// named state does not exist.
+//
+// An entry guard is suitable for a rule which applies to every change into
+// a state regardless of the state being left.
+func (st *StateTrans) AddEntryGuard(name string, fn func(f *FSM) error,
+) error {
+	s, err := st.getState(name)
+	if err != nil {
+		return err
+	}
+
+	s.entryGuards = append(s.entryGuards, fn)
+	return nil
+}
+
+// AddPureGlobalGuard adds a global guard (see AddGlobalGuard) which the
+// caller promises is pure: it has no side effects and its result depends
+// only on its arguments and the state of the FSM. Pure guards are used by
+// GuardedReachability as well as when the FSM changes state.
+func (st *StateTrans) AddPureGlobalGuard(
+	fn func(f *FSM, from, to string) error,
+) {
+	st.globalGuards = append(st.globalGuards, fn)
+	st.pureGlobalGuards = append(st.pureGlobalGuards, fn)
+}
 
-	fmt.Fprintln(w, "}")
+// AddPureEntryGuard adds an entry guard (see AddEntryGuard) which the caller
+// promises is pure: it has no side effects and its result depends only on
+// the state of the FSM. Pure guards are used by GuardedReachability as
+// well as when the FSM changes state. It will return an error if the named
+// state does not exist.
+func (st *StateTrans) AddPureEntryGuard(name string, fn func(f *FSM) error,
+) error {
+	s, err := st.getState(name)
+	if err != nil {
+		return err
+	}
+
+	s.entryGuards = append(s.entryGuards, fn)
+	s.pureEntryGuards = append(s.pureEntryGuards, fn)
+	return nil
+}
+
+// HasGuard returns true if a guard would be called when an FSM using this
+// StateTrans changes from the 'from' state to the 'to' state, that is, if
+// there are any global guards or the 'to' state has an entry guard. It
+// returns false if there is no such transition. Since functions can't be
+// compared this only reports whether a guard is present, it is not called.
+func (st StateTrans) HasGuard(from, to string) bool {
+	_, ts, err := st.getTransition(from, to)
+	if err != nil {
+		return false
+	}
+	return len(st.globalGuards) > 0 || len(ts.entryGuards) > 0
+}
+
+// HasEntryGuard returns true if the named state has an entry guard (see
+// AddEntryGuard and AddPureEntryGuard). It returns false if the state does
+// not exist.
+func (st StateTrans) HasEntryGuard(name string) bool {
+	s, err := st.getState(name)
+	if err != nil {
+		return false
+	}
+	return len(s.entryGuards) > 0
+}
+
+// HasSuccessorResolver returns true if the named state has a successor
+// resolver (see SetSuccessorResolver). It returns false if the state does
+// not exist.
+func (st StateTrans) HasSuccessorResolver(name string) bool {
+	s, err := st.getState(name)
+	if err != nil {
+		return false
+	}
+	return s.resolver != nil
+}
+
+// SetStateDescLocale sets the description of the state for the given
+// locale. It will return an error if the named state does not exist.
+func (st *StateTrans) SetStateDescLocale(name, locale, desc string) error {
+	s, err := st.getState(name)
+	if err != nil {
+		return err
+	}
+
+	if s.localeDesc == nil {
+		s.localeDesc = make(map[string]string)
+	}
+	s.localeDesc[locale] = desc
+	return nil
+}
+
+// StateDescLocale returns the description of the named state for the given
+// locale and true if one has been set. Otherwise it returns the default
+// description of the state (as set by SetStateDesc) and false. If the state
+// does not exist it returns an empty string and false.
+func (st StateTrans) StateDescLocale(name, locale string) (string, bool) {
+	s, err := st.getState(name)
+	if err != nil {
+		return "", false
+	}
+
+	if desc, ok := s.localeDesc[locale]; ok {
+		return desc, true
+	}
+	return s.desc, false
+}
+
+// SetDescriptions sets the state descriptions from the values given in the
+// slice of state descriptions. It will return an error if any
+// state does not exist and will set the error state on the StateTrans. It will
+// also return an error if the StateTrans already has its error state set.
+func (st *StateTrans) SetDescriptions(descriptions ...StateDesc) error {
+	for _, sd := range descriptions {
+		err := st.SetStateDesc(sd.Name, sd.Desc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
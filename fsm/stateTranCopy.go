@@ -0,0 +1,112 @@
+package fsm
+
+// copyMap returns a copy of the map. A nil map is returned as nil.
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// copyStates returns a copy of the map of next states with each state
+// replaced by the state of the same name from the states map.
+func copyStates(m, states map[string]*state) map[string]*state {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]*state, len(m))
+	for name := range m {
+		c[name] = states[name]
+	}
+	return c
+}
+
+// Copy returns a new StateTrans which is an independent copy of this one.
+// The states, their descriptions, groups, metadata and every other setting
+// are copied as are the transitions between them and any guards. Changes
+// made to the copy will not affect the original, nor any FSM using it, and
+// vice versa. Instance tracking (see TrackInstances) is not copied.
+func (st StateTrans) Copy() *StateTrans {
+	c := &StateTrans{
+		name:     st.name,
+		states:   make(map[string]*state, len(st.states)),
+		foldCase: st.foldCase,
+		folded:   copyMap(st.folded),
+		aliases:  copyMap(st.aliases),
+		allowed:  copyMap(st.allowed),
+		version:  st.version,
+		extra:    copyMap(st.extra),
+		globalGuards: append([]func(f *FSM, from, to string) error(nil),
+			st.globalGuards...),
+		pureGlobalGuards: append([]func(f *FSM, from, to string) error(nil),
+			st.pureGlobalGuards...),
+	}
+
+	for name, s := range st.states {
+		sc := *s
+		c.states[name] = &sc
+	}
+
+	for _, s := range c.states {
+		s.localeDesc = copyMap(s.localeDesc)
+		s.meta = copyMap(s.meta)
+		s.nextState = copyStates(s.nextState, c.states)
+		s.mustHaveVisited = append([]string(nil), s.mustHaveVisited...)
+		s.deprecatedNext = copyMap(s.deprecatedNext)
+		s.forbiddenNext = copyMap(s.forbiddenNext)
+		s.reopenTo = copyStates(s.reopenTo, c.states)
+		s.minInterval = copyMap(s.minInterval)
+		s.entryGuards = append([]func(f *FSM) error(nil), s.entryGuards...)
+		s.pureEntryGuards = append([]func(f *FSM) error(nil),
+			s.pureEntryGuards...)
+		s.cost = copyMap(s.cost)
+		s.events = copyMap(s.events)
+	}
+
+	return c
+}
+
+// Pruned returns a copy of the StateTrans (see Copy) from which the states
+// that cannot be reached from the InitState (see UnreachableStates) have
+// been removed, together with the transitions from them and any aliases
+// for them. Any reopen transitions into the removed states are also
+// removed. The original StateTrans is not changed. This can be used, for
+// instance, to draw a diagram of only the live part of a StateTrans.
+func (st StateTrans) Pruned() *StateTrans {
+	c := st.Copy()
+
+	removed := map[string]bool{}
+	for _, name := range st.UnreachableStates() {
+		removed[name] = true
+		delete(c.states, name)
+	}
+	if len(removed) == 0 {
+		return c
+	}
+
+	for k, name := range c.folded {
+		if removed[name] {
+			delete(c.folded, k)
+		}
+	}
+	for k, name := range c.aliases {
+		if removed[name] {
+			delete(c.aliases, k)
+		}
+	}
+	for _, s := range c.states {
+		for name := range s.reopenTo {
+			if removed[name] {
+				delete(s.reopenTo, name)
+			}
+		}
+	}
+
+	return c
+}
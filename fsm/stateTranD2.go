@@ -0,0 +1,54 @@
+package fsm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// d2Escaper escapes a string so that it can be used in a D2 double-quoted
+// string
+var d2Escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// d2Quote returns the string escaped and in double quotes
+func d2Quote(s string) string {
+	return `"` + d2Escaper.Replace(s) + `"`
+}
+
+// PrintD2 prints the state transitions as a diagram in the D2 diagram
+// language. Every state is drawn as a circle; the InitState and the
+// terminal states have a double border and are filled in the same colours
+// as in PrintDot. Any state description is given as a tooltip. The states
+// are written in the order given by OrderedStates and then the
+// transitions, sorted by the 'from' state and then the 'to' state, so the
+// output is always the same for the same StateTrans.
+func (st StateTrans) PrintD2(w io.Writer) {
+	fmt.Fprintln(w, "# A state transition graph for")
+	fmt.Fprintln(w, "#      ", st.name)
+
+	names := st.OrderedStates()
+	for _, name := range names {
+		s := st.states[name]
+
+		fmt.Fprintf(w, "%s: {\n", d2Quote(name))
+		fmt.Fprintln(w, "  shape: circle")
+		switch {
+		case name == InitState:
+			fmt.Fprintln(w, "  style.double-border: true")
+			fmt.Fprintln(w, "  style.fill: lightblue")
+		case s.isTerminal():
+			fmt.Fprintln(w, "  style.double-border: true")
+			fmt.Fprintln(w, `  style.fill: "#d9d9d9"`)
+		}
+		if s.desc != "" {
+			fmt.Fprintf(w, "  tooltip: %s\n", d2Quote(s.desc))
+		}
+		fmt.Fprintln(w, "}")
+	}
+
+	for _, name := range names {
+		for _, nextName := range st.states[name].nextNames() {
+			fmt.Fprintf(w, "%s -> %s\n", d2Quote(name), d2Quote(nextName))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package fsm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DSL syntax elements
+const (
+	dslArrow   = "->"
+	dslAltSep  = "|"
+	dslComment = "#"
+)
+
+// ParseDSL constructs a new StateTrans with the given name from the
+// transitions read from r. Each line gives the transitions from a single
+// state in the form:
+//
+//	from -> to
+//
+// Several next states can be given on one line by separating them with a
+// '|' so that:
+//
+//	UnderReview -> Rejected | ReadyToFix
+//
+// gives two transitions. Anything following a '#' is a comment and is
+// ignored as are blank lines and any white space around the state
+// names. The transitions are added in the order given and the same
+// validation is applied as in NewStateTrans so the 'from' state must have
+// been created by an earlier line (or be the InitState). If there are any
+// problems a nil StateTrans and the error are returned.
+func ParseDSL(name string, r io.Reader) (*StateTrans, error) {
+	var transitions []STPair
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		pairs, err := parseDSLLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", name, lineNum, err)
+		}
+		transitions = append(transitions, pairs...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return NewStateTrans(name, transitions...)
+}
+
+// parseDSLLine parses a single line of the DSL, returning the transitions
+// it gives. A blank or comment-only line gives no transitions.
+func parseDSLLine(line string) ([]STPair, error) {
+	if i := strings.Index(line, dslComment); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(line, dslArrow)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%q: there must be exactly one %q",
+			line, dslArrow)
+	}
+
+	from := strings.TrimSpace(parts[0])
+	if from == "" {
+		return nil, fmt.Errorf("%q: the 'from' state is missing", line)
+	}
+
+	pairs := []STPair{}
+	for _, to := range strings.Split(parts[1], dslAltSep) {
+		to = strings.TrimSpace(to)
+		if to == "" {
+			return nil, fmt.Errorf("%q: a 'to' state is missing", line)
+		}
+		pairs = append(pairs, STPair{From: from, To: to})
+	}
+
+	return pairs, nil
+}
@@ -0,0 +1,80 @@
+package fsm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestParseDSL(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		dsl       string
+		expTrans  int
+		expStates []string
+	}{
+		{
+			ID: testhelper.MkID("good"),
+			dsl: `# a review workflow
+init -> ReadyToReview
+
+ReadyToReview -> UnderReview   # picked up
+UnderReview -> Rejected | ReadyToFix
+   ReadyToFix->UnderReview
+`,
+			expTrans: 5,
+			expStates: []string{
+				fsm.InitState, "ReadyToFix", "ReadyToReview",
+				"Rejected", "UnderReview",
+			},
+		},
+		{
+			ID:        testhelper.MkID("good - empty"),
+			dsl:       "# nothing here\n\n",
+			expStates: []string{fsm.InitState},
+		},
+		{
+			ID:  testhelper.MkID("bad - no arrow"),
+			dsl: "init -> A\nA B\n",
+			ExpErr: testhelper.MkExpErr(
+				`test: line 2: "A B": there must be exactly one "->"`),
+		},
+		{
+			ID:  testhelper.MkID("bad - two arrows"),
+			dsl: "init -> A -> B\n",
+			ExpErr: testhelper.MkExpErr(
+				`test: line 1: "init -> A -> B": there must be exactly one`),
+		},
+		{
+			ID:  testhelper.MkID("bad - missing from"),
+			dsl: " -> A\n",
+			ExpErr: testhelper.MkExpErr(
+				`test: line 1: "-> A": the 'from' state is missing`),
+		},
+		{
+			ID:  testhelper.MkID("bad - missing to"),
+			dsl: "init -> A |\n",
+			ExpErr: testhelper.MkExpErr(
+				`test: line 1: "init -> A |": a 'to' state is missing`),
+		},
+		{
+			ID:  testhelper.MkID("bad - from state doesn't exist"),
+			dsl: "A -> B\ninit -> A\n",
+			ExpErr: testhelper.MkExpErr(
+				"test: state: 'A' does not exist", "failed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		st, err := fsm.ParseDSL("test", strings.NewReader(tc.dsl))
+		if testhelper.CheckExpErr(t, err, tc) && err == nil {
+			testhelper.DiffInt(t, tc.IDStr(), "number of transitions",
+				st.TransitionCount(), tc.expTrans)
+			testhelper.DiffStringSlice(t, tc.IDStr(), "states",
+				st.OrderedStates(), tc.expStates)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package fsm
+
+import "sort"
+
+// StateTransDiff records the differences between two StateTrans. The
+// states and transitions are those added or removed in going from the
+// first StateTrans to the second. Each slice is sorted.
+type StateTransDiff struct {
+	AddedStates        []string
+	RemovedStates      []string
+	AddedTransitions   []STPair
+	RemovedTransitions []STPair
+}
+
+// IsEmpty returns true if there are no differences
+func (d StateTransDiff) IsEmpty() bool {
+	return len(d.AddedStates) == 0 &&
+		len(d.RemovedStates) == 0 &&
+		len(d.AddedTransitions) == 0 &&
+		len(d.RemovedTransitions) == 0
+}
+
+// Diff returns the differences between this StateTrans and the other. The
+// added states and transitions are those present in the other StateTrans
+// but not in this one and the removed ones are those present in this one
+// but not in the other. Descriptions and names are not compared.
+func (st StateTrans) Diff(other *StateTrans) StateTransDiff {
+	return StateTransDiff{
+		AddedStates:        missingStates(other, &st),
+		RemovedStates:      missingStates(&st, other),
+		AddedTransitions:   missingTransitions(other, &st),
+		RemovedTransitions: missingTransitions(&st, other),
+	}
+}
+
+// missingStates returns the sorted names of the states in a that are not
+// in b
+func missingStates(a, b *StateTrans) []string {
+	missing := []string{}
+	for name := range a.states {
+		if _, ok := b.states[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// missingTransitions returns the sorted transitions in a that are not in b
+func missingTransitions(a, b *StateTrans) []STPair {
+	missing := []STPair{}
+	for from, s := range a.states {
+		bs, ok := b.states[from]
+		for to := range s.nextState {
+			if ok {
+				if _, ok := bs.nextState[to]; ok {
+					continue
+				}
+			}
+			missing = append(missing, STPair{From: from, To: to})
+		}
+	}
+	sortSTPairs(missing)
+	return missing
+}
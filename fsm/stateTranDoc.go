@@ -0,0 +1,402 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// stDoc is the document form of a StateTrans. It is used when marshalling
+// and unmarshalling the StateTrans, whether as YAML or JSON, and is intended
+// to be easy to read and to edit by hand. Apart from the name and the
+// transitions the parts are only present if they have been set. Any
+// entries in the document which are not recognised are kept in Extra and
+// written back out when the StateTrans is marshalled so that documents
+// written by later versions of this package survive being loaded and
+// saved.
+//
+// The document records everything about the StateTrans which is held as
+// data. It cannot record the global and entry guards (pure or not) or the
+// successor resolvers as these are functions, nor whether the StateTrans
+// is tracking its instances. The set of allowed states given to
+// NewStateTransClosed is not recorded either; it only restricts the states
+// when the StateTrans is created. These must all be set again on the
+// StateTrans after it has been unmarshalled.
+//
+// The rate limits are recorded in the form used by time.Duration.String
+// and the Success map only has entries for terminal states which have been
+// classified (see StateTrans.ClassifyTerminal).
+type stDoc struct {
+	Name           string                       `yaml:"name" json:"name"`
+	Version        string                       `yaml:"version,omitempty" json:"version,omitempty"`
+	FoldCase       bool                         `yaml:"foldCase,omitempty" json:"foldCase,omitempty"`
+	Transitions    map[string][]string          `yaml:"transitions" json:"transitions"`
+	Reopen         map[string][]string          `yaml:"reopen,omitempty" json:"reopen,omitempty"`
+	Descriptions   map[string]string            `yaml:"descriptions,omitempty" json:"descriptions,omitempty"`
+	Locales        map[string]map[string]string `yaml:"locales,omitempty" json:"locales,omitempty"`
+	Aliases        map[string]string            `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+	Groups         map[string]string            `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Meta           map[string]map[string]string `yaml:"meta,omitempty" json:"meta,omitempty"`
+	Success        map[string]bool              `yaml:"success,omitempty" json:"success,omitempty"`
+	AutoAdvance    []string                     `yaml:"autoAdvance,omitempty" json:"autoAdvance,omitempty"`
+	RequireVisited map[string][]string          `yaml:"requireVisited,omitempty" json:"requireVisited,omitempty"`
+	Costs          map[string]map[string]int    `yaml:"costs,omitempty" json:"costs,omitempty"`
+	RateLimits     map[string]map[string]string `yaml:"rateLimits,omitempty" json:"rateLimits,omitempty"`
+	Deprecated     map[string]map[string]string `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+	Forbidden      map[string][]string          `yaml:"forbidden,omitempty" json:"forbidden,omitempty"`
+	Events         map[string]map[string]string `yaml:"events,omitempty" json:"events,omitempty"`
+
+	Extra map[string]any `yaml:",inline" json:"-"`
+}
+
+// stDocKeys lists the names of the entries of the JSON form of the stDoc.
+// It must be kept in step with the json tags of the stDoc fields.
+var stDocKeys = []string{
+	"name",
+	"version",
+	"foldCase",
+	"transitions",
+	"reopen",
+	"descriptions",
+	"locales",
+	"aliases",
+	"groups",
+	"meta",
+	"success",
+	"autoAdvance",
+	"requireVisited",
+	"costs",
+	"rateLimits",
+	"deprecated",
+	"forbidden",
+	"events",
+}
+
+// stDocFields has the same fields as stDoc but none of its methods. It is
+// used to marshal and unmarshal the known fields of the stDoc as JSON.
+type stDocFields stDoc
+
+// MarshalJSON returns the JSON form of the document including any extra
+// entries
+func (doc stDoc) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(stDocFields(doc))
+	if err != nil || len(doc.Extra) == 0 {
+		return data, err
+	}
+
+	entries := map[string]any{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for k, v := range doc.Extra {
+		if _, ok := entries[k]; !ok {
+			entries[k] = v
+		}
+	}
+	return json.Marshal(entries)
+}
+
+// UnmarshalJSON populates the document from the JSON data, keeping any
+// entries which are not recognised in Extra
+func (doc *stDoc) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*stDocFields)(doc)); err != nil {
+		return err
+	}
+
+	entries := map[string]any{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, k := range stDocKeys {
+		delete(entries, k)
+	}
+	doc.Extra = nil
+	if len(entries) > 0 {
+		doc.Extra = entries
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addEntry sets the value for the key in the map, first creating the map
+// if it is nil
+func addEntry[V any](m *map[string]V, key string, val V) {
+	if *m == nil {
+		*m = make(map[string]V)
+	}
+	(*m)[key] = val
+}
+
+// mkDoc returns the document form of the StateTrans. Every state appears in
+// the Transitions map, terminal states having an empty list of targets.
+func (st StateTrans) mkDoc() stDoc {
+	doc := stDoc{
+		Name:        st.name,
+		Version:     st.version,
+		FoldCase:    st.foldCase,
+		Transitions: make(map[string][]string, len(st.states)),
+		Extra:       copyMap(st.extra),
+	}
+
+	for k, name := range st.aliases {
+		addEntry(&doc.Aliases, k, name)
+	}
+
+	for _, name := range sortedNames(st.states) {
+		s := st.states[name]
+
+		doc.Transitions[name] = s.nextNames()
+		doc.addStateDetails(s)
+		doc.addTransitionDetails(s)
+	}
+
+	return doc
+}
+
+// addStateDetails adds to the document those details of the state which
+// have been set, such as its description
+func (doc *stDoc) addStateDetails(s *state) {
+	if s.desc != "" {
+		addEntry(&doc.Descriptions, s.name, s.desc)
+	}
+	if len(s.localeDesc) > 0 {
+		addEntry(&doc.Locales, s.name, copyMap(s.localeDesc))
+	}
+	if s.group != "" {
+		addEntry(&doc.Groups, s.name, s.group)
+	}
+	if len(s.meta) > 0 {
+		addEntry(&doc.Meta, s.name, copyMap(s.meta))
+	}
+	if s.classified {
+		addEntry(&doc.Success, s.name, s.success)
+	}
+	if s.autoAdvance {
+		doc.AutoAdvance = append(doc.AutoAdvance, s.name)
+	}
+	if len(s.mustHaveVisited) > 0 {
+		addEntry(&doc.RequireVisited, s.name,
+			append([]string(nil), s.mustHaveVisited...))
+	}
+}
+
+// addTransitionDetails adds to the document those details of the
+// transitions from the state which have been set, such as their costs
+func (doc *stDoc) addTransitionDetails(s *state) {
+	if len(s.reopenTo) > 0 {
+		addEntry(&doc.Reopen, s.name, sortedNames(s.reopenTo))
+	}
+	if len(s.cost) > 0 {
+		addEntry(&doc.Costs, s.name, copyMap(s.cost))
+	}
+	if len(s.minInterval) > 0 {
+		limits := make(map[string]string, len(s.minInterval))
+		for to, d := range s.minInterval {
+			limits[to] = d.String()
+		}
+		addEntry(&doc.RateLimits, s.name, limits)
+	}
+	if len(s.deprecatedNext) > 0 {
+		addEntry(&doc.Deprecated, s.name, copyMap(s.deprecatedNext))
+	}
+	if len(s.forbiddenNext) > 0 {
+		addEntry(&doc.Forbidden, s.name, sortedKeys(s.forbiddenNext))
+	}
+	if len(s.events) > 0 {
+		addEntry(&doc.Events, s.name, copyMap(s.events))
+	}
+}
+
+// stateTrans constructs a StateTrans from the document. The transitions are
+// added in breadth-first order starting from the InitState so that, as
+// required by NewStateTrans, every 'from' state exists before any
+// transition from it is added. Any transitions from states that cannot be
+// reached in this way are added last and so will be reported as errors by
+// NewStateTrans.
+func (doc stDoc) stateTrans() (*StateTrans, error) {
+	transitions := []STPair{}
+	seen := map[string]bool{InitState: true}
+	queue := []string{InitState}
+
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+
+		targets := append([]string{}, doc.Transitions[from]...)
+		sort.Strings(targets)
+		for _, to := range targets {
+			transitions = append(transitions, STPair{From: from, To: to})
+			if !seen[to] {
+				seen[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(doc.Transitions))
+	for name := range doc.Transitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, from := range names {
+		if seen[from] {
+			continue
+		}
+		for _, to := range doc.Transitions[from] {
+			transitions = append(transitions, STPair{From: from, To: to})
+		}
+	}
+
+	newST := NewStateTrans
+	if doc.FoldCase {
+		newST = NewStateTransFoldCase
+	}
+	st, err := newST(doc.Name, transitions...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if !st.HasState(name) {
+			return nil, fmt.Errorf("%s: state: %q cannot be reached from %q",
+				st.name, name, InitState)
+		}
+	}
+
+	if err := doc.setStateDetails(st); err != nil {
+		return nil, err
+	}
+	if err := doc.setTransitionDetails(st); err != nil {
+		return nil, err
+	}
+
+	st.version = doc.Version
+	st.extra = copyMap(doc.Extra)
+
+	return st, nil
+}
+
+// setStateDetails sets the aliases and the details of the states given in
+// the document on the StateTrans. The aliases are set first so that they
+// can be used in place of the state names. The states are taken in sorted
+// order so that any error reported is predictable.
+func (doc stDoc) setStateDetails(st *StateTrans) error {
+	for _, alias := range sortedKeys(doc.Aliases) {
+		if err := st.AddAlias(doc.Aliases[alias], alias); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(doc.Descriptions) {
+		if err := st.SetStateDesc(name, doc.Descriptions[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(doc.Locales) {
+		locales := doc.Locales[name]
+		for _, locale := range sortedKeys(locales) {
+			err := st.SetStateDescLocale(name, locale, locales[locale])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range sortedKeys(doc.Groups) {
+		if err := st.SetStateGroup(name, doc.Groups[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(doc.Meta) {
+		if err := st.SetStateMeta(name, doc.Meta[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(doc.Success) {
+		if err := st.ClassifyTerminal(name, doc.Success[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range doc.AutoAdvance {
+		if err := st.SetAutoAdvance(name); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(doc.RequireVisited) {
+		err := st.RequireVisited(name, doc.RequireVisited[name]...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setTransitionDetails sets the reopen transitions and the details of the
+// transitions given in the document on the StateTrans. The states are taken
+// in sorted order so that any error reported is predictable.
+func (doc stDoc) setTransitionDetails(st *StateTrans) error {
+	for _, from := range sortedKeys(doc.Reopen) {
+		for _, to := range doc.Reopen[from] {
+			if err := st.AddReopen(from, to); err != nil {
+				return err
+			}
+		}
+	}
+	for _, from := range sortedKeys(doc.Costs) {
+		costs := doc.Costs[from]
+		for _, to := range sortedKeys(costs) {
+			if err := st.SetTransitionCost(from, to, costs[to]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, from := range sortedKeys(doc.RateLimits) {
+		limits := doc.RateLimits[from]
+		for _, to := range sortedKeys(limits) {
+			d, err := time.ParseDuration(limits[to])
+			if err != nil {
+				return fmt.Errorf("%s: the rate limit of the transition"+
+					" from %q to %q is not valid: %w",
+					st.name, from, to, err)
+			}
+			if err := st.SetRateLimit(from, to, d); err != nil {
+				return err
+			}
+		}
+	}
+	for _, from := range sortedKeys(doc.Deprecated) {
+		notes := doc.Deprecated[from]
+		for _, to := range sortedKeys(notes) {
+			if err := st.DeprecateTransition(from, to, notes[to]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, from := range sortedKeys(doc.Forbidden) {
+		for _, to := range doc.Forbidden[from] {
+			if err := st.ForbidTransition(from, to); err != nil {
+				return err
+			}
+		}
+	}
+	for _, from := range sortedKeys(doc.Events) {
+		events := doc.Events[from]
+		for _, event := range sortedKeys(events) {
+			if err := st.AddEvent(event, from, events[event]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package fsm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PrintDot prints the state transitions as a directed graph in the
+// graphviz DOT language. The output of this func can be interpreted by the
+// dot command (on Linux). To generate a png file from this you could write
+// the output to a file called, for instance, stateTrans.gv and then use the
+// following command to generate the png image and write it to a file called
+// graph.png
+//
+//	dot -Tpng -ograph.png stateTrans.gv
+//
+// This might be useful for generating documentation for your package.
+// Alternatively, WritePNG will run the dot command for you.
+//
+// Any states which have been given a group (see SetStateGroup) are drawn
+// together in a cluster labelled with the group name.
+//
+// Only the transitions given when the StateTrans was created are drawn;
+// the transitions found at run time by a successor resolver (see
+// SetSuccessorResolver) cannot be known in advance and are not shown.
+//
+// The initial and terminal states are styled through default node
+// attributes set at the start of the graph; only those nodes whose style
+// differs from these defaults, such as classified terminal states (see
+// ClassifyTerminal), are given attributes of their own.
+func (st StateTrans) PrintDot(w io.Writer) {
+	st.printDot(w, nil)
+}
+
+// WriteDotBody writes the node and edge statements describing the state
+// transitions in the graphviz DOT language. Unlike PrintDot it does not
+// write the enclosing graph, any graph-level attributes or any default
+// node attributes; instead every node is given its attributes explicitly
+// so that the statements can be included in a larger graph.
+func (st StateTrans) WriteDotBody(w io.Writer) {
+	st.writeDotBody(w, nil, true)
+}
+
+// printDot prints the state transitions as a complete directed graph in
+// the graphviz DOT language. The nodes named in the highlights map are
+// given the associated attributes, overriding the style they would
+// otherwise have.
+func (st StateTrans) printDot(w io.Writer, highlights map[string]string) {
+	fmt.Fprintln(w, "// A state transition graph for")
+	fmt.Fprintln(w, "//      ", st.name)
+	fmt.Fprintln(w, "digraph st {")
+
+	st.writeDotNodeDefaults(w)
+	st.writeDotBody(w, highlights, false)
+
+	fmt.Fprintln(w, "    fontsize=22")
+
+	fmt.Fprintf(w, "    label = \"\n%s\n\"\n", dotEscape(st.name))
+
+	fmt.Fprintln(w, "}")
+}
+
+// dotEscape returns the string with any double quotes escaped so that it
+// can be used in a DOT quoted string
+func dotEscape(s string) string {
+	return strings.ReplaceAll(s, "\"", "\\\"")
+}
+
+// writeDotNodeDefaults writes the default node attributes used by
+// PrintDot, setting the style of the initial state, then that of the
+// terminal states and finally that of all the other states.
+func (st StateTrans) writeDotNodeDefaults(w io.Writer) {
+	terminals := []string{}
+	for _, name := range st.OrderedStates() {
+		if st.states[name].isTerminal() {
+			terminals = append(terminals, "\""+dotEscape(name)+"\"")
+		}
+	}
+
+	fmt.Fprintln(w, "    node [shape = doublecircle")
+	fmt.Fprintln(w, "          style=filled fillcolor=lightblue];")
+	fmt.Fprintf(w, "        \"%s\";\n", InitState)
+	fmt.Fprintln(w, "    node [shape = doublecircle")
+	fmt.Fprintln(w, "          style=filled fillcolor=grey85];")
+	fmt.Fprintf(w, "        %s;\n", strings.Join(terminals, ", "))
+	fmt.Fprintln(w, "    node [shape = circle style=solid];")
+}
+
+// dotOutcomeStyle returns the DOT style showing the outcome of the state if
+// it is a classified terminal state (see ClassifyTerminal). Otherwise it
+// returns the empty string.
+func dotOutcomeStyle(s *state) string {
+	if s.name == InitState || !s.isTerminal() || !s.classified {
+		return ""
+	}
+	if s.success {
+		return "style=filled fillcolor=palegreen"
+	}
+	return "style=filled fillcolor=lightcoral"
+}
+
+// dotNodeAttrs returns the full DOT attributes for the state. Any highlight
+// attributes replace the style that the node would otherwise have.
+func (st StateTrans) dotNodeAttrs(s *state, highlight string) string {
+	shape := "shape=circle"
+	style := ""
+	switch {
+	case s.name == InitState:
+		shape = "shape=doublecircle"
+		style = "style=filled fillcolor=lightblue"
+	case s.isTerminal():
+		shape = "shape=doublecircle"
+		style = "style=filled fillcolor=grey85"
+		if outcome := dotOutcomeStyle(s); outcome != "" {
+			style = outcome
+		}
+	}
+
+	if highlight != "" {
+		style = highlight
+	}
+	if style == "" {
+		return shape
+	}
+	return shape + " " + style
+}
+
+// dotNodeOverride returns the DOT attributes needed for the state to
+// differ from the default node attributes written by writeDotNodeDefaults.
+// These are the highlight attributes, if any, or else the style showing
+// the outcome of a classified terminal state. The empty string is returned
+// if the defaults apply unchanged.
+func dotNodeOverride(s *state, highlight string) string {
+	if highlight != "" {
+		return highlight
+	}
+	return dotOutcomeStyle(s)
+}
+
+// writeDotBody writes the node and edge statements describing the state
+// transitions in the graphviz DOT language. The nodes named in the
+// highlights map are given the associated attributes, overriding the style
+// they would otherwise have. If allAttrs is true every node is given its
+// full attributes, otherwise only those nodes which differ from the
+// defaults written by writeDotNodeDefaults are given attributes.
+func (st StateTrans) writeDotBody(w io.Writer,
+	highlights map[string]string, allAttrs bool,
+) {
+	namesInOrder := st.OrderedStates()
+
+	terminals := []string{}
+	for _, name := range namesInOrder {
+		s := st.states[name]
+		if allAttrs {
+			fmt.Fprintf(w, "    \"%s\" [%s];\n",
+				dotEscape(name), st.dotNodeAttrs(s, highlights[name]))
+		}
+		if s.isTerminal() {
+			terminals = append(terminals, dotEscape(name))
+		}
+	}
+
+	fmt.Fprintln(w, "    { rank = same;")
+	fmt.Fprint(w, "        ")
+	for _, name := range terminals {
+		fmt.Fprintf(w, "\"%s\" ", name)
+	}
+	fmt.Fprintln(w, "}")
+
+	if !allAttrs {
+		for _, name := range namesInOrder {
+			attrs := dotNodeOverride(st.states[name], highlights[name])
+			if attrs != "" {
+				fmt.Fprintf(w, "    \"%s\" [%s];\n", dotEscape(name), attrs)
+			}
+		}
+	}
+
+	groups := st.Groups()
+	groupNames := make([]string, 0, len(groups))
+	for g := range groups {
+		groupNames = append(groupNames, g)
+	}
+	sort.Strings(groupNames)
+
+	for _, g := range groupNames {
+		fmt.Fprintf(w, "    subgraph \"cluster_%s\" {\n", dotEscape(g))
+		fmt.Fprintf(w, "        label = \"%s\";\n", dotEscape(g))
+		for _, name := range groups[g] {
+			fmt.Fprintf(w, "        \"%s\";\n", dotEscape(name))
+		}
+		fmt.Fprintln(w, "    }")
+	}
+
+	for _, name := range namesInOrder {
+		for _, nextName := range st.states[name].nextNames() {
+			fmt.Fprintf(w, "    \"%s\" -> \"%s\"\n",
+				dotEscape(name), dotEscape(nextName))
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package fsm_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestPrintDotGroups(t *testing.T) {
+	st, err := fsm.NewStateTrans("grouped",
+		fsm.STPair{fsm.InitState, "Review"},
+		fsm.STPair{"Review", "Fix"},
+		fsm.STPair{"Fix", "Test"},
+		fsm.STPair{"Test", "Done"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.SetStateGroup("Fix", "Development")
+	_ = st.SetStateGroup("Test", "Development")
+	_ = st.SetStateGroup("Review", `"Triage"`)
+
+	err = st.SetStateGroup("nonesuch", "Development")
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`grouped: state: "nonesuch" does not exist`))
+
+	var buf bytes.Buffer
+	st.PrintDot(&buf)
+	testhelper.DiffString(t, "grouped", "DOT output", buf.String(),
+		`// A state transition graph for
+//       grouped
+digraph st {
+    node [shape = doublecircle
+          style=filled fillcolor=lightblue];
+        "init";
+    node [shape = doublecircle
+          style=filled fillcolor=grey85];
+        "Done";
+    node [shape = circle style=solid];
+    { rank = same;
+        "Done" }
+    subgraph "cluster_\"Triage\"" {
+        label = "\"Triage\"";
+        "Review";
+    }
+    subgraph "cluster_Development" {
+        label = "Development";
+        "Fix";
+        "Test";
+    }
+    "init" -> "Review"
+    "Fix" -> "Test"
+    "Review" -> "Fix"
+    "Test" -> "Done"
+    fontsize=22
+    label = "
+grouped
+"
+}
+`)
+}
+
+func TestPrintDotNoTerminals(t *testing.T) {
+	st, err := fsm.NewStateTrans("cycle",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var buf bytes.Buffer
+	st.PrintDot(&buf)
+	testhelper.DiffString(t, "no terminals", "DOT output", buf.String(),
+		`// A state transition graph for
+//       cycle
+digraph st {
+    node [shape = doublecircle
+          style=filled fillcolor=lightblue];
+        "init";
+    node [shape = doublecircle
+          style=filled fillcolor=grey85];
+        ;
+    node [shape = circle style=solid];
+    { rank = same;
+        }
+    "init" -> "A"
+    "A" -> "B"
+    "B" -> "A"
+    fontsize=22
+    label = "
+cycle
+"
+}
+`)
+}
+
+func TestGroups(t *testing.T) {
+	st, err := fsm.NewStateTrans("grouped",
+		fsm.STPair{fsm.InitState, "Review"},
+		fsm.STPair{"Review", "Fix"},
+		fsm.STPair{"Fix", "Test"},
+		fsm.STPair{"Test", "Done"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.SetStateGroup("Test", "Development")
+	_ = st.SetStateGroup("Fix", "Development")
+	_ = st.SetStateGroup("Review", "Triage")
+
+	testCases := []struct {
+		testhelper.ID
+		name     string
+		expGroup string
+		expFound bool
+	}{
+		{
+			ID:       testhelper.MkID("grouped"),
+			name:     "Fix",
+			expGroup: "Development",
+			expFound: true,
+		},
+		{
+			ID:   testhelper.MkID("not grouped"),
+			name: "Done",
+		},
+		{
+			ID:   testhelper.MkID("unknown state"),
+			name: "nonesuch",
+		},
+	}
+
+	for _, tc := range testCases {
+		group, found := st.StateGroup(tc.name)
+		testhelper.DiffString(t, tc.IDStr(), "group", group, tc.expGroup)
+		testhelper.DiffBool(t, tc.IDStr(), "found", found, tc.expFound)
+	}
+
+	expGroups := map[string][]string{
+		"Development": {"Fix", "Test"},
+		"Triage":      {"Review"},
+	}
+	if err := testhelper.DiffVals(st.Groups(), expGroups); err != nil {
+		t.Errorf("groups: %s", err)
+	}
+}
+
+func TestWriteDotBody(t *testing.T) {
+	st, err := fsm.NewStateTrans("lifecycle",
+		fsm.STPair{fsm.InitState, "start"},
+		fsm.STPair{"start", "finish"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var buf bytes.Buffer
+	st.WriteDotBody(&buf)
+	testhelper.DiffString(t, "body", "DOT output", buf.String(),
+		`    "init" [shape=doublecircle style=filled fillcolor=lightblue];
+    "finish" [shape=doublecircle style=filled fillcolor=grey85];
+    "start" [shape=circle];
+    { rank = same;
+        "finish" }
+    "init" -> "start"
+    "start" -> "finish"
+`)
+}
+
+func TestOrderedStates(t *testing.T) {
+	st, err := fsm.NewStateTrans("test",
+		fsm.STPair{fsm.InitState, "b"},
+		fsm.STPair{"b", "a"},
+		fsm.STPair{"a", "Z"},
+		fsm.STPair{"a", "j"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testhelper.DiffStringSlice(t, "ordered", "states", st.OrderedStates(),
+		[]string{fsm.InitState, "Z", "a", "b", "j"})
+}
+
+func TestWritePNG(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses shell scripts")
+	}
+
+	st, err := fsm.NewStateTrans("test",
+		fsm.STPair{fsm.InitState, "start"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	dir := t.TempDir()
+	mkScript := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o700)
+		if err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+		return path
+	}
+
+	var expDot bytes.Buffer
+	st.PrintDot(&expDot)
+
+	var buf bytes.Buffer
+	err = st.WritePNGUsing(&buf, mkScript("fakeDot", `cat`))
+	if err != nil {
+		t.Error("fake dot: unexpected error:", err)
+	}
+	testhelper.DiffString(t, "fake dot", "output",
+		buf.String(), expDot.String())
+
+	err = st.WritePNGUsing(&buf,
+		mkScript("badDot", `echo "syntax error" >&2; exit 1`))
+	testhelper.CheckExpErrWithID(t, "failing dot", err,
+		testhelper.MkExpErr("badDot", "failed", "syntax error"))
+
+	err = st.WritePNGUsing(&buf, filepath.Join(dir, "nonesuch"))
+	testhelper.CheckExpErrWithID(t, "missing dot", err,
+		testhelper.MkExpErr("test: cannot find the graphviz command"))
+}
+
+func TestPrintD2(t *testing.T) {
+	st, err := fsm.NewStateTrans(`my "D2" graph`,
+		fsm.STPair{fsm.InitState, "start"},
+		fsm.STPair{"start", "Finish"},
+		fsm.STPair{"start", "start"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.SetStateDesc("start", `the "first" state`)
+
+	var buf bytes.Buffer
+	st.PrintD2(&buf)
+	testhelper.DiffString(t, "D2", "output", buf.String(),
+		`# A state transition graph for
+#       my "D2" graph
+"init": {
+  shape: circle
+  style.double-border: true
+  style.fill: lightblue
+  tooltip: "the initial state"
+}
+"Finish": {
+  shape: circle
+  style.double-border: true
+  style.fill: "#d9d9d9"
+}
+"start": {
+  shape: circle
+  tooltip: "the \"first\" state"
+}
+"init" -> "start"
+"start" -> "Finish"
+"start" -> "start"
+`)
+}
+
+func TestWriteDotBodyOutcomes(t *testing.T) {
+	st, err := fsm.NewStateTrans("test",
+		fsm.STPair{fsm.InitState, "good"},
+		fsm.STPair{fsm.InitState, "bad"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.ClassifyTerminal("good", true)
+	_ = st.ClassifyTerminal("bad", false)
+
+	var buf bytes.Buffer
+	st.WriteDotBody(&buf)
+	testhelper.DiffString(t, "outcomes", "DOT output", buf.String(),
+		`    "init" [shape=doublecircle style=filled fillcolor=lightblue];
+    "bad" [shape=doublecircle style=filled fillcolor=lightcoral];
+    "good" [shape=doublecircle style=filled fillcolor=palegreen];
+    { rank = same;
+        "bad" "good" }
+    "init" -> "bad"
+    "init" -> "good"
+`)
+
+	buf.Reset()
+	st.PrintDot(&buf)
+	testhelper.DiffString(t, "outcomes", "PrintDot output", buf.String(),
+		`// A state transition graph for
+//       test
+digraph st {
+    node [shape = doublecircle
+          style=filled fillcolor=lightblue];
+        "init";
+    node [shape = doublecircle
+          style=filled fillcolor=grey85];
+        "bad", "good";
+    node [shape = circle style=solid];
+    { rank = same;
+        "bad" "good" }
+    "bad" [style=filled fillcolor=lightcoral];
+    "good" [style=filled fillcolor=palegreen];
+    "init" -> "bad"
+    "init" -> "good"
+    fontsize=22
+    label = "
+test
+"
+}
+`)
+}
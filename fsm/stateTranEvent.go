@@ -0,0 +1,53 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AddEvent gives a name to the transition from the 'from' state to the 'to'
+// state. The FSM can then be moved along the transition by firing the event
+// (see FSM.FireEvent) rather than by naming the new state. The same event
+// name can be used from several states, for instance an "approve" event
+// might lead from each review state to the next, but an event can only
+// lead to one state from any given state. It will return an error if
+// either of the named states or the transition between them does not
+// exist or if the event has already been added from the 'from' state.
+func (st *StateTrans) AddEvent(event, from, to string) error {
+	fs, ts, err := st.getTransition(from, to)
+	if err != nil {
+		return err
+	}
+	if target, ok := fs.events[event]; ok {
+		return fmt.Errorf("%s: event: %q already leads from %q to %q",
+			st.name, event, fs.name, target)
+	}
+
+	if fs.events == nil {
+		fs.events = make(map[string]string)
+	}
+	fs.events[event] = ts.name
+	return nil
+}
+
+// eventNames returns the names of the events which can be fired from the
+// state in sorted order
+func (s state) eventNames() []string {
+	names := make([]string, 0, len(s.events))
+	for name := range s.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EventsFrom returns the sorted names of the events which can be fired
+// from the named state (see AddEvent). It will return an error if the
+// state does not exist.
+func (st StateTrans) EventsFrom(name string) ([]string, error) {
+	s, err := st.getState(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.eventNames(), nil
+}
@@ -0,0 +1,52 @@
+package fsm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Fingerprint returns a hex-encoded SHA-256 hash of the states, their
+// descriptions and the transitions between them. The states and
+// transitions are hashed in sorted order so the result does not depend on
+// the order in which they were added. The name of the StateTrans is not
+// included so two StateTrans which differ only in their names will have the
+// same fingerprint.
+//
+// This can be stored alongside a persisted FSM so that any later change to
+// the StateTrans can be detected.
+func (st StateTrans) Fingerprint() string {
+	return st.fingerprint(false)
+}
+
+// VersionedFingerprint returns a fingerprint in the same way as Fingerprint
+// but the version of the StateTrans (see SetVersion) is also included.
+func (st StateTrans) VersionedFingerprint() string {
+	return st.fingerprint(true)
+}
+
+// fingerprint returns the hex-encoded SHA-256 hash of the StateTrans,
+// including the version if withVersion is true
+func (st StateTrans) fingerprint(withVersion bool) string {
+	names := make([]string, 0, len(st.states))
+	for name := range st.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	if withVersion {
+		fmt.Fprintf(h, "version: %q\n", st.version)
+	}
+	for _, name := range names {
+		s := st.states[name]
+		fmt.Fprintf(h, "state: %q %q\n", name, s.desc)
+
+		for _, nextName := range s.nextNames() {
+			fmt.Fprintf(h, "transition: %q %q\n", name, nextName)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
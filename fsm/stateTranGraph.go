@@ -0,0 +1,467 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// reachableFrom returns the set of states which can be reached from the
+// given state by some sequence of one or more transitions.
+func reachableFrom(from *state) map[string]bool {
+	reached := map[string]bool{}
+	queue := []*state{from}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		for name, ns := range s.nextState {
+			if !reached[name] {
+				reached[name] = true
+				queue = append(queue, ns)
+			}
+		}
+	}
+
+	return reached
+}
+
+// unreachableFrom returns the sorted names of those states which cannot be
+// reached from the given state by any sequence of one or more transitions.
+func (st StateTrans) unreachableFrom(from *state) []string {
+	reached := reachableFrom(from)
+
+	unreachable := []string{}
+	for name := range st.states {
+		if !reached[name] {
+			unreachable = append(unreachable, name)
+		}
+	}
+	sort.Strings(unreachable)
+
+	return unreachable
+}
+
+// ReachabilityClosure returns a map from the name of each state to the
+// sorted names of all the states which can be reached from it by some
+// sequence of one or more transitions. A state is only included in its own
+// list if it can be returned to, either through a cycle or through a
+// transition to itself. A terminal state has an empty list.
+func (st StateTrans) ReachabilityClosure() map[string][]string {
+	closure := make(map[string][]string, len(st.states))
+	for name, s := range st.states {
+		reached := reachableFrom(s)
+
+		names := make([]string, 0, len(reached))
+		for r := range reached {
+			names = append(names, r)
+		}
+		sort.Strings(names)
+		closure[name] = names
+	}
+
+	return closure
+}
+
+// StatesCommittedTo returns the sorted names of the states from which the
+// named terminal state is the only terminal state that can be reached; once
+// an FSM is in one of these states the outcome is certain, unless it never
+// finishes. The terminal state itself is included. It returns nil if the
+// named state does not exist or is not a terminal state.
+func (st StateTrans) StatesCommittedTo(terminal string) []string {
+	ts, err := st.getState(terminal)
+	if err != nil || !ts.isTerminal() {
+		return nil
+	}
+
+	committed := []string{}
+	for name, s := range st.states {
+		reached := reachableFrom(s)
+		reached[name] = true
+		if !reached[ts.name] {
+			continue
+		}
+
+		onlyTerminal := true
+		for r := range reached {
+			if r != ts.name && st.states[r].isTerminal() {
+				onlyTerminal = false
+				break
+			}
+		}
+		if onlyTerminal {
+			committed = append(committed, name)
+		}
+	}
+	sort.Strings(committed)
+
+	return committed
+}
+
+// UnreachableStates returns the sorted names of those states which cannot
+// be reached from the InitState by any sequence of transitions. The
+// InitState itself is never reported. A StateTrans built with NewStateTrans
+// has no unreachable states but one built with NewStateTransAuto may.
+func (st StateTrans) UnreachableStates() []string {
+	unreachable := []string{}
+	for _, name := range st.unreachableFrom(st.states[InitState]) {
+		if name != InitState {
+			unreachable = append(unreachable, name)
+		}
+	}
+	return unreachable
+}
+
+// PathsToTerminals returns every simple path (one in which no state is
+// repeated) from the InitState to a terminal state. Each path starts with
+// the InitState and ends with the terminal state. The paths are returned in
+// a deterministic order, the next states being explored in sorted order. In
+// a graph with many cycles the number of paths can be very large so no
+// more than maxPaths paths will be returned. A maxPaths value less than 1
+// means there is no limit.
+func (st StateTrans) PathsToTerminals(maxPaths int) [][]string {
+	paths := [][]string{}
+	onPath := map[string]bool{}
+	path := []string{}
+
+	var walk func(s *state) bool
+	walk = func(s *state) bool {
+		path = append(path, s.name)
+		onPath[s.name] = true
+		defer func() {
+			path = path[:len(path)-1]
+			onPath[s.name] = false
+		}()
+
+		if s.isTerminal() {
+			paths = append(paths, append([]string{}, path...))
+			return maxPaths < 1 || len(paths) < maxPaths
+		}
+
+		for _, name := range s.nextNames() {
+			if onPath[name] {
+				continue
+			}
+			if !walk(s.nextState[name]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	walk(st.states[InitState])
+
+	return paths
+}
+
+// LongestPathToTerminal returns the longest path from the InitState to a
+// terminal state. Finding the longest simple path in a graph with cycles
+// is not practical in general so the search is restricted to the acyclic
+// graph left once any transitions which lead back to a state already on
+// the path being explored are ignored (these are found by a depth-first
+// search taking the next states in sorted order). Where there are several
+// paths of the same length the first found is returned. An error is
+// returned if no terminal state can be reached in this way.
+func (st StateTrans) LongestPathToTerminal() ([]string, error) {
+	memo := map[string][]string{}
+	onPath := map[string]bool{}
+
+	var longest func(s *state) []string
+	longest = func(s *state) []string {
+		if p, ok := memo[s.name]; ok {
+			return p
+		}
+
+		onPath[s.name] = true
+		var best []string
+		if s.isTerminal() {
+			best = []string{s.name}
+		}
+		for _, name := range s.nextNames() {
+			if onPath[name] {
+				continue
+			}
+			if p := longest(s.nextState[name]); p != nil &&
+				len(p)+1 > len(best) {
+				best = append([]string{s.name}, p...)
+			}
+		}
+		onPath[s.name] = false
+
+		memo[s.name] = best
+		return best
+	}
+
+	path := longest(st.states[InitState])
+	if path == nil {
+		return nil, fmt.Errorf("%s: no terminal state can be reached from %q",
+			st.name, InitState)
+	}
+	return path, nil
+}
+
+// IsWeaklyConnected returns true if every state is connected to every
+// other state when the direction of the transitions is ignored.
+func (st StateTrans) IsWeaklyConnected() bool {
+	parent := make(map[string]string, len(st.states))
+	for name := range st.states {
+		parent[name] = name
+	}
+
+	var find func(name string) string
+	find = func(name string) string {
+		if parent[name] != name {
+			parent[name] = find(parent[name])
+		}
+		return parent[name]
+	}
+
+	components := len(st.states)
+	for name, s := range st.states {
+		for nextName := range s.nextState {
+			if a, b := find(name), find(nextName); a != b {
+				parent[a] = b
+				components--
+			}
+		}
+	}
+
+	return components <= 1
+}
+
+// EquivalentStateGroups returns groups of states which have exactly the
+// same set of next states; such states might be merged into one. Note
+// that all the terminal states have the same (empty) set of next states
+// and so will form a group. Only groups of more than one state are
+// returned. The states in each group are sorted and the groups are sorted
+// by their first state.
+func (st StateTrans) EquivalentStateGroups() [][]string {
+	byNext := map[string][]string{}
+	for _, name := range st.OrderedStates() {
+		key := strings.Join(st.states[name].nextNames(), "\x00")
+		byNext[key] = append(byNext[key], name)
+	}
+
+	groups := [][]string{}
+	for _, names := range byNext {
+		if len(names) > 1 {
+			sort.Strings(names)
+			groups = append(groups, names)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+
+	return groups
+}
+
+// BidirectionalPairs returns each pair of distinct states which have
+// transitions between them in both directions. Each pair is given once,
+// with the From state being the lesser of the two names, and the pairs are
+// sorted. A state with a transition to itself is not reported.
+func (st StateTrans) BidirectionalPairs() []STPair {
+	pairs := []STPair{}
+	for name, s := range st.states {
+		for nextName, ns := range s.nextState {
+			if name >= nextName {
+				continue
+			}
+			if _, ok := ns.nextState[name]; ok {
+				pairs = append(pairs, STPair{From: name, To: nextName})
+			}
+		}
+	}
+	sortSTPairs(pairs)
+
+	return pairs
+}
+
+// pureGuardsAllow returns true if none of the pure guards (see
+// AddPureGlobalGuard and AddPureEntryGuard) forbid the change from the
+// 'from' state to the 'to' state for the given FSM. A transition blocked by
+// ForbidTransition is also reported as not allowed.
+func (st StateTrans) pureGuardsAllow(f *FSM, from, to *state) bool {
+	if from.forbiddenNext[to.name] {
+		return false
+	}
+	for _, g := range st.pureGlobalGuards {
+		if g(f, from.name, to.name) != nil {
+			return false
+		}
+	}
+	for _, g := range to.pureEntryGuards {
+		if g(f) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// GuardedReachability returns the sorted names of the states which cannot
+// be reached from the current state of the FSM when only the transitions
+// allowed by the pure guards (see AddPureGlobalGuard and
+// AddPureEntryGuard) are followed. Each guard is called with the FSM as it
+// is now, not as it would be after the intervening changes of state, and
+// guards not registered as pure are ignored, as is the Underlying. The
+// current state is always reachable. The current state of the FSM is
+// found by name in this StateTrans and so the FSM would normally be one
+// using it; an error is returned if this StateTrans has no such state.
+func (st StateTrans) GuardedReachability(f *FSM) ([]string, error) {
+	current, err := st.getState(f.current.name)
+	if err != nil {
+		return nil, err
+	}
+
+	reached := map[string]bool{current.name: true}
+	queue := []*state{current}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, name := range s.nextNames() {
+			ns := s.nextState[name]
+			if reached[name] || !st.pureGuardsAllow(f, s, ns) {
+				continue
+			}
+			reached[name] = true
+			queue = append(queue, ns)
+		}
+	}
+
+	unreachable := []string{}
+	for name := range st.states {
+		if !reached[name] {
+			unreachable = append(unreachable, name)
+		}
+	}
+	sort.Strings(unreachable)
+
+	return unreachable, nil
+}
+
+// Degree records the number of transitions into (In) and out of (Out) a
+// state
+type Degree struct {
+	In  int
+	Out int
+}
+
+// Degrees returns the Degree of each state, keyed by state name. A
+// transition from a state to itself counts towards both its In and Out
+// values.
+func (st StateTrans) Degrees() map[string]Degree {
+	degrees := make(map[string]Degree, len(st.states))
+	for name, s := range st.states {
+		d := degrees[name]
+		d.Out = len(s.nextState)
+		degrees[name] = d
+
+		for nextName := range s.nextState {
+			d := degrees[nextName]
+			d.In++
+			degrees[nextName] = d
+		}
+	}
+
+	return degrees
+}
+
+// StatesByDegree returns the names of the states sorted by their total
+// number of transitions, in and out, with the most connected first. States
+// with the same total are sorted by name. This can be used as a hint for
+// laying out a diagram of the StateTrans, with the busiest states placed
+// centrally.
+func (st StateTrans) StatesByDegree() []string {
+	degrees := st.Degrees()
+
+	names := make([]string, 0, len(degrees))
+	for name := range degrees {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := degrees[names[i]], degrees[names[j]]
+		if ti, tj := di.In+di.Out, dj.In+dj.Out; ti != tj {
+			return ti > tj
+		}
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// transitionCost returns the cost of the transition from the state to the
+// named next state (see SetTransitionCost)
+func (s state) transitionCost(next string) int {
+	if cost, ok := s.cost[next]; ok {
+		return cost
+	}
+	return 1
+}
+
+// CheapestPath returns the path from the 'from' state to the 'to' state
+// with the lowest total cost (see SetTransitionCost) and that cost. The
+// path starts with the 'from' state and ends with the 'to' state. Where
+// there are several paths with the same cost the one found first, taking
+// the next states in sorted order, is returned. An error is returned if
+// either state does not exist or there is no path between them.
+func (st StateTrans) CheapestPath(from, to string) ([]string, int, error) {
+	fs, err := st.getState(from)
+	if err != nil {
+		return nil, 0, err
+	}
+	ts, err := st.getState(to)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dist := map[string]int{fs.name: 0}
+	prev := map[string]string{}
+	done := map[string]bool{}
+
+	for {
+		cur := ""
+		for name, d := range dist {
+			if done[name] {
+				continue
+			}
+			if cur == "" || d < dist[cur] || (d == dist[cur] && name < cur) {
+				cur = name
+			}
+		}
+		if cur == "" {
+			break
+		}
+		if cur == ts.name {
+			break
+		}
+		done[cur] = true
+
+		s := st.states[cur]
+		for _, next := range s.nextNames() {
+			d := dist[cur] + s.transitionCost(next)
+			if old, ok := dist[next]; !ok || d < old {
+				dist[next] = d
+				prev[next] = cur
+			}
+		}
+	}
+
+	cost, ok := dist[ts.name]
+	if !ok {
+		return nil, 0, fmt.Errorf("%s: there is no path from %q to %q",
+			st.name, fs.name, ts.name)
+	}
+
+	path := []string{ts.name}
+	for name := ts.name; name != fs.name; {
+		name = prev[name]
+		path = append(path, name)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path, cost, nil
+}
@@ -0,0 +1,111 @@
+package fsm
+
+import (
+	"sort"
+	"sync"
+)
+
+// instances is a concurrency-safe register of the FSMs created from a
+// StateTrans. It also keeps a count of the number of registered FSMs in
+// each state.
+type instances struct {
+	mu      sync.Mutex
+	nextSeq uint64
+	fsms    map[*FSM]uint64
+	counts  map[string]int
+}
+
+// add records the FSM in the register
+func (i *instances) add(f *FSM) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.fsms[f] = i.nextSeq
+	i.nextSeq++
+	i.counts[f.current.name]++
+}
+
+// remove deletes the FSM from the register
+func (i *instances) remove(f *FSM) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.fsms[f]; !ok {
+		return
+	}
+	delete(i.fsms, f)
+	i.counts[f.current.name]--
+}
+
+// move records the change of state of the FSM in the counts of FSMs in each
+// state. It does nothing if the FSM is not registered.
+func (i *instances) move(f *FSM, from, to string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.fsms[f]; !ok {
+		return
+	}
+	i.counts[from]--
+	i.counts[to]++
+}
+
+// TrackInstances turns on the tracking of the FSMs created from the
+// StateTrans. Every FSM subsequently created by New will be recorded until
+// its Close method is called; FSMs created before this is called are not
+// tracked. Calling this more than once has no further effect.
+//
+// Note that a tracked FSM will not be garbage collected until it has been
+// closed so it is important to call Close when an FSM is no longer needed.
+func (st *StateTrans) TrackInstances() {
+	if st.inst == nil {
+		st.inst = &instances{
+			fsms:   make(map[*FSM]uint64),
+			counts: make(map[string]int),
+		}
+	}
+}
+
+// Instances returns the tracked FSMs created from this StateTrans, in the
+// order in which they were created. It returns nil if instances are not
+// being tracked (see TrackInstances). It is safe to call this while FSMs
+// are being created or closed.
+func (st StateTrans) Instances() []*FSM {
+	if st.inst == nil {
+		return nil
+	}
+
+	st.inst.mu.Lock()
+	defer st.inst.mu.Unlock()
+
+	fsms := make([]*FSM, 0, len(st.inst.fsms))
+	for f := range st.inst.fsms {
+		fsms = append(fsms, f)
+	}
+	sort.Slice(fsms, func(i, j int) bool {
+		return st.inst.fsms[fsms[i]] < st.inst.fsms[fsms[j]]
+	})
+
+	return fsms
+}
+
+// StateHistogram returns a map from each state name to the number of
+// tracked FSMs currently in that state. Every state is present in the map,
+// even if no FSM is in it. It returns nil if instances are not being
+// tracked (see TrackInstances). It is safe to call this while the tracked
+// FSMs are changing state.
+func (st StateTrans) StateHistogram() map[string]int {
+	if st.inst == nil {
+		return nil
+	}
+
+	st.inst.mu.Lock()
+	defer st.inst.mu.Unlock()
+
+	hist := make(map[string]int, len(st.states))
+	for name := range st.states {
+		hist[name] = st.inst.counts[name]
+	}
+
+	return hist
+}
@@ -0,0 +1,286 @@
+package fsm_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestInstances(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	untracked := fsm.New(st, nil)
+	if inst := st.Instances(); inst != nil {
+		t.Errorf("untracked StateTrans: expected nil instances, got: %v",
+			inst)
+	}
+
+	st.TrackInstances()
+	f1 := fsm.New(st, nil)
+	f2 := fsm.New(st, nil)
+	f3 := fsm.New(st, nil)
+
+	testhelper.DiffSlice(t, "tracked", "instances",
+		st.Instances(), []*fsm.FSM{f1, f2, f3})
+
+	f2.Close()
+	f2.Close()
+	untracked.Close()
+	testhelper.DiffSlice(t, "after close", "instances",
+		st.Instances(), []*fsm.FSM{f1, f3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f := fsm.New(st, nil)
+			_ = st.Instances()
+			f.Close()
+		}()
+	}
+	wg.Wait()
+	testhelper.DiffSlice(t, "after concurrent use", "instances",
+		st.Instances(), []*fsm.FSM{f1, f3})
+}
+
+func TestStateHistogram(t *testing.T) {
+	st, err := fsm.NewStateTrans("testFSM",
+		fsm.STPair{fsm.InitState, "state1"},
+		fsm.STPair{"state1", "state2"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	if hist := st.StateHistogram(); hist != nil {
+		t.Errorf("untracked StateTrans: expected a nil histogram, got: %v",
+			hist)
+	}
+
+	untracked := fsm.New(st, nil)
+	st.TrackInstances()
+
+	fsms := []*fsm.FSM{}
+	for i := 0; i < 5; i++ {
+		fsms = append(fsms, fsm.New(st, nil))
+	}
+	_ = untracked.ChangeState("state1")
+	_ = fsms[0].ChangeState("state1")
+	_ = fsms[1].ChangeState("state1")
+	_ = fsms[1].ChangeState("state2")
+	fsms[4].Close()
+
+	expHist := map[string]int{
+		fsm.InitState: 2,
+		"state1":      1,
+		"state2":      1,
+	}
+	if err := testhelper.DiffVals(st.StateHistogram(), expHist); err != nil {
+		t.Errorf("histogram: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, f := range fsms[2:4] {
+		wg.Add(1)
+		go func(f *fsm.FSM) {
+			defer wg.Done()
+			_ = f.ChangeState("state1")
+		}(f)
+	}
+	for i := 0; i < 10; i++ {
+		_ = st.StateHistogram()
+	}
+	wg.Wait()
+
+	expHist = map[string]int{
+		fsm.InitState: 0,
+		"state1":      3,
+		"state2":      1,
+	}
+	if err := testhelper.DiffVals(st.StateHistogram(), expHist); err != nil {
+		t.Errorf("histogram after concurrent changes: %s", err)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	st, err := fsm.NewStateTrans("test\nFSM",
+		fsm.STPair{fsm.InitState, `say "hi"`},
+		fsm.STPair{fsm.InitState, `back\slash`})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	var buf bytes.Buffer
+	err = st.WritePrometheus(&buf, "fsm_states")
+	testhelper.CheckExpErrWithID(t, "untracked", err,
+		testhelper.MkExpErr("instances are not being tracked"))
+
+	st.TrackInstances()
+	f := fsm.New(st, nil)
+	_ = f.ChangeState(`say "hi"`)
+	fsm.New(st, nil)
+
+	err = st.WritePrometheus(&buf, "bad name")
+	testhelper.CheckExpErrWithID(t, "bad name", err,
+		testhelper.MkExpErr(`"bad name" is not a valid metric name`))
+
+	buf.Reset()
+	if err = st.WritePrometheus(&buf, "fsm_states"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	testhelper.DiffString(t, "tracked", "output", buf.String(),
+		"# HELP fsm_states The number of FSMs in each state of test\\nFSM\n"+
+			"# TYPE fsm_states gauge\n"+
+			`fsm_states{state="init"} 1`+"\n"+
+			`fsm_states{state="back\\slash"} 0`+"\n"+
+			`fsm_states{state="say \"hi\""} 1`+"\n")
+}
+
+func TestSwapStateTrans(t *testing.T) {
+	oldST, err := fsm.NewStateTrans("old",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	oldST.TrackInstances()
+
+	newST, err := fsm.NewStateTrans("new",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	newST.TrackInstances()
+
+	badST, err := fsm.NewStateTrans("bad",
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{"B", "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	movable := fsm.New(oldST, nil)
+	_ = movable.ChangeState("A")
+	_ = movable.ChangeState("B")
+	fresh := fsm.New(oldST, nil)
+
+	for _, f := range oldST.Instances() {
+		if err := f.SwapStateTrans(newST); err != nil {
+			t.Error("unexpected error:", err)
+		}
+	}
+	testhelper.DiffInt(t, "swapped", "old instances",
+		len(oldST.Instances()), 0)
+	testhelper.DiffInt(t, "swapped", "new instances",
+		len(newST.Instances()), 2)
+	testhelper.DiffInt(t, "swapped", "new instances in B",
+		newST.StateHistogram()["B"], 1)
+	testhelper.DiffString(t, "swapped", "name", movable.Name(), "new")
+	testhelper.DiffString(t, "swapped", "current state",
+		movable.CurrentState(), "B")
+	if err := movable.ChangeState("C"); err != nil {
+		t.Error("swapped: unexpected error:", err)
+	}
+
+	err = movable.SwapStateTrans(badST)
+	testhelper.CheckExpErrWithID(t, "missing state", err,
+		testhelper.MkExpErr(`FSM: "new": cannot change the StateTrans:`,
+			`bad: state: "C" does not exist`))
+	testhelper.DiffString(t, "missing state", "name", movable.Name(), "new")
+
+	_ = fresh.ChangeState("A")
+	err = fresh.SwapStateTrans(badST)
+	testhelper.CheckExpErrWithID(t, "no transition", err,
+		testhelper.MkExpErr(
+			`bad: there is no transition from "init" to "A"`))
+
+	err = fresh.SwapStateTrans(nil)
+	testhelper.CheckExpErrWithID(t, "nil", err,
+		testhelper.MkExpErr(`FSM: "new": the new StateTrans must not be nil`))
+}
+
+func TestSwapStateTransRenamed(t *testing.T) {
+	oldST, err := fsm.NewStateTrans("old",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	aliasST, err := fsm.NewStateTrans("alias",
+		fsm.STPair{fsm.InitState, "Alpha"},
+		fsm.STPair{"Alpha", "Beta"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	if err = aliasST.AddAlias("Alpha", "A"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	if err = aliasST.AddAlias("Beta", "B"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	foldST, err := fsm.NewStateTransFoldCase("fold",
+		fsm.STPair{fsm.InitState, "a"},
+		fsm.STPair{"a", "b"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		st         *fsm.StateTrans
+		expCurrent string
+		expPrior   string
+		expNext    string
+	}{
+		{
+			ID:         testhelper.MkID("alias"),
+			st:         aliasST,
+			expCurrent: "Alpha",
+			expPrior:   fsm.InitState,
+			expNext:    "Beta",
+		},
+		{
+			ID:         testhelper.MkID("fold case"),
+			st:         foldST,
+			expCurrent: "a",
+			expPrior:   fsm.InitState,
+			expNext:    "b",
+		},
+	}
+
+	for _, tc := range testCases {
+		f := fsm.New(oldST, nil)
+		if err := f.ChangeState("A"); err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+
+		if err := f.SwapStateTrans(tc.st); err != nil {
+			t.Log(tc.IDStr())
+			t.Error("\t: unexpected error:", err)
+			continue
+		}
+		testhelper.DiffString(t, tc.IDStr(), "current state",
+			f.CurrentState(), tc.expCurrent)
+		testhelper.DiffString(t, tc.IDStr(), "current state (atomic)",
+			f.CurrentStateAtomic(), tc.expCurrent)
+		testhelper.DiffString(t, tc.IDStr(), "prior state",
+			f.PriorState(), tc.expPrior)
+		if err := f.ChangeState("B"); err != nil {
+			t.Log(tc.IDStr())
+			t.Error("\t: unexpected error:", err)
+		}
+		testhelper.DiffString(t, tc.IDStr(), "next state",
+			f.CurrentState(), tc.expNext)
+	}
+}
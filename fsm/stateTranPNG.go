@@ -0,0 +1,50 @@
+package fsm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// DotCommand is the name of the graphviz command used by WritePNG
+const DotCommand = "dot"
+
+// WritePNG writes the state transitions, as drawn by PrintDot, to w as a
+// PNG image. It runs the graphviz dot command, which must be installed and
+// on the PATH, so it can only be used where graphviz is available. An error
+// is returned if the command cannot be found or fails.
+func (st StateTrans) WritePNG(w io.Writer) error {
+	return st.WritePNGUsing(w, DotCommand)
+}
+
+// WritePNGUsing writes the state transitions to w as a PNG image in the
+// same way as WritePNG but the graphviz dot command to be run is given by
+// cmdPath. This may be a full pathname or a name to be found on the PATH.
+func (st StateTrans) WritePNGUsing(w io.Writer, cmdPath string) error {
+	path, err := exec.LookPath(cmdPath)
+	if err != nil {
+		return fmt.Errorf(
+			"%s: cannot find the graphviz command (is graphviz installed?): %w",
+			st.name, err)
+	}
+
+	var dot bytes.Buffer
+	st.PrintDot(&dot)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, "-Tpng")
+	cmd.Stdin = &dot
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %q failed: %w: %s", st.name, path, err, msg)
+		}
+		return fmt.Errorf("%s: %q failed: %w", st.name, path, err)
+	}
+
+	return nil
+}
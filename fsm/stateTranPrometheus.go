@@ -0,0 +1,55 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// metricNameRE matches a valid Prometheus metric name
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// promLabelEscaper escapes a Prometheus label value
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promHelpEscaper escapes Prometheus HELP text
+var promHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// WritePrometheus writes the number of tracked FSMs in each state (see
+// StateHistogram) in the Prometheus text exposition format. The metric is
+// a gauge with the given name and a "state" label; a HELP and a TYPE line
+// are written first and then one line per state in the order given by
+// OrderedStates. An error is returned if instances are not being tracked
+// (see TrackInstances), if the metric name is not valid or if the writing
+// fails.
+func (st StateTrans) WritePrometheus(w io.Writer, metricName string) error {
+	if !metricNameRE.MatchString(metricName) {
+		return fmt.Errorf("%s: %q is not a valid metric name",
+			st.name, metricName)
+	}
+
+	hist := st.StateHistogram()
+	if hist == nil {
+		return errors.New(st.name + ": instances are not being tracked")
+	}
+
+	_, err := fmt.Fprintf(w,
+		"# HELP %s The number of FSMs in each state of %s\n"+
+			"# TYPE %s gauge\n",
+		metricName, promHelpEscaper.Replace(st.name), metricName)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range st.OrderedStates() {
+		_, err := fmt.Fprintf(w, "%s{state=\"%s\"} %d\n",
+			metricName, promLabelEscaper.Replace(name), hist[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
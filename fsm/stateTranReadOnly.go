@@ -0,0 +1,102 @@
+package fsm
+
+import "io"
+
+// ReadOnlyStateTrans gives access to those methods of a StateTrans which do
+// not change it. A value of this type can be passed to code which should
+// only inspect or render the StateTrans, for instance a library function
+// drawing diagrams, with the guarantee that it cannot change the states,
+// their descriptions or the transitions between them.
+type ReadOnlyStateTrans interface {
+	Name() string
+	HasState(name string) bool
+	States() []string
+	OrderedStates() []string
+	NextStatesOf(name string) []string
+	StateCount() int
+	TransitionCount() int
+	Successors(name string) (map[string]string, error)
+	GroupStates() (nonTerminal, terminal []string)
+	StateGroup(name string) (string, bool)
+	StateMeta(name string) (map[string]string, bool)
+	Summary() string
+	Fingerprint() string
+	PrintDot(w io.Writer)
+	Copy() *StateTrans
+}
+
+// readOnlyStateTrans wraps a StateTrans so that only the methods of the
+// ReadOnlyStateTrans interface are available. The StateTrans cannot be
+// recovered by a type assertion.
+type readOnlyStateTrans struct {
+	st *StateTrans
+}
+
+// ReadOnly returns a read-only view of the StateTrans. Changes made to the
+// StateTrans are visible through the view. Note that the Copy method of the
+// view returns a copy which can be changed freely.
+func (st *StateTrans) ReadOnly() ReadOnlyStateTrans {
+	return readOnlyStateTrans{st: st}
+}
+
+// States returns the names of all the states in sorted order
+func (st StateTrans) States() []string {
+	return sortedNames(st.states)
+}
+
+// NextStatesOf returns the names of the next states of the named state in
+// sorted order. It returns nil if the state does not exist.
+func (st StateTrans) NextStatesOf(name string) []string {
+	s, err := st.getState(name)
+	if err != nil {
+		return nil
+	}
+	return s.nextNames()
+}
+
+func (ro readOnlyStateTrans) Name() string { return ro.st.Name() }
+
+func (ro readOnlyStateTrans) HasState(name string) bool {
+	return ro.st.HasState(name)
+}
+
+func (ro readOnlyStateTrans) States() []string { return ro.st.States() }
+
+func (ro readOnlyStateTrans) OrderedStates() []string {
+	return ro.st.OrderedStates()
+}
+
+func (ro readOnlyStateTrans) NextStatesOf(name string) []string {
+	return ro.st.NextStatesOf(name)
+}
+
+func (ro readOnlyStateTrans) StateCount() int { return ro.st.StateCount() }
+
+func (ro readOnlyStateTrans) TransitionCount() int {
+	return ro.st.TransitionCount()
+}
+
+func (ro readOnlyStateTrans) Successors(name string,
+) (map[string]string, error) {
+	return ro.st.Successors(name)
+}
+
+func (ro readOnlyStateTrans) GroupStates() (nonTerminal, terminal []string) {
+	return ro.st.GroupStates()
+}
+
+func (ro readOnlyStateTrans) StateGroup(name string) (string, bool) {
+	return ro.st.StateGroup(name)
+}
+
+func (ro readOnlyStateTrans) StateMeta(name string) (map[string]string, bool) {
+	return ro.st.StateMeta(name)
+}
+
+func (ro readOnlyStateTrans) Summary() string { return ro.st.Summary() }
+
+func (ro readOnlyStateTrans) Fingerprint() string { return ro.st.Fingerprint() }
+
+func (ro readOnlyStateTrans) PrintDot(w io.Writer) { ro.st.PrintDot(w) }
+
+func (ro readOnlyStateTrans) Copy() *StateTrans { return ro.st.Copy() }
@@ -0,0 +1,83 @@
+package fsm
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is the maximum number of suggested state names given for
+// an unknown state
+const maxSuggestions = 3
+
+// suggestNames returns the names of the states which are close to the given
+// name, as measured by the edit distance between them ignoring case, the
+// closest first and then in name order. A state is close if no more than
+// one edit in three characters of the name, and at least one, is needed.
+// No more than maxSuggestions names are returned.
+func (st StateTrans) suggestNames(name string) []string {
+	maxDist := len([]rune(name)) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+
+	dist := map[string]int{}
+	for sName := range st.states {
+		d := editDistance(strings.ToLower(name), strings.ToLower(sName))
+		if d <= maxDist {
+			dist[sName] = d
+		}
+	}
+
+	names := make([]string, 0, len(dist))
+	for sName := range dist {
+		names = append(names, sName)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if di, dj := dist[names[i]], dist[names[j]]; di != dj {
+			return di < dj
+		}
+		return names[i] < names[j]
+	})
+
+	if len(names) > maxSuggestions {
+		names = names[:maxSuggestions]
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between the two strings:
+// the number of single character insertions, deletions or substitutions
+// needed to turn one into the other.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// minInt returns the smallest of the values
+func minInt(v int, vals ...int) int {
+	for _, x := range vals {
+		if x < v {
+			v = x
+		}
+	}
+	return v
+}
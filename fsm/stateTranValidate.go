@@ -0,0 +1,165 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ValidateStrict checks that every terminal state has a description. The
+// terminal states are the outcomes of the FSM and so an undescribed
+// terminal state is usually an oversight. If any terminal state has no
+// description an error is returned with one line for each such state, in
+// the order given by OrderedStates, otherwise nil is returned.
+func (st StateTrans) ValidateStrict() error {
+	problems := []string{}
+
+	for _, name := range st.OrderedStates() {
+		s := st.states[name]
+		if s.isTerminal() && s.desc == "" {
+			problems = append(problems,
+				fmt.Sprintf("%s: terminal state: %q has no description",
+					st.name, name))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+// CheckAdditions checks that the transitions could be added, in the order
+// given, to those from which the StateTrans was made: the 'from' state of
+// each must either be a state of the StateTrans or the 'to' state of an
+// earlier addition and the names must be acceptable, as for NewStateTrans.
+// The StateTrans is not changed. If there are any problems an error is
+// returned with one line for each problem, otherwise nil is returned.
+func (st StateTrans) CheckAdditions(additions ...STPair) error {
+	problems := []string{}
+	added := map[string]bool{}
+
+	for _, stp := range additions {
+		if err := st.checkAddition(stp, added); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		added[stp.To] = true
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+// checkAddition returns an error if the transition could not be added to
+// the StateTrans given the names of the states already added.
+func (st StateTrans) checkAddition(stp STPair, added map[string]bool) error {
+	for _, name := range []string{stp.From, stp.To} {
+		if err := st.checkCase(name); err != nil {
+			return err
+		}
+		if st.allowed != nil && !st.allowed[name] {
+			return fmt.Errorf(
+				"%s: state: '%s' is not allowed. Add('%s', '%s') failed",
+				st.name, name, stp.From, stp.To)
+		}
+	}
+
+	if !st.HasState(stp.From) && !added[stp.From] {
+		return fmt.Errorf(
+			"%s: state: '%s' does not exist. Add('%s', '%s') failed",
+			st.name, stp.From, stp.From, stp.To)
+	}
+	return nil
+}
+
+// AssertExclusiveTerminals checks that no state of the StateTrans can
+// reach more than one of the given terminal states in a single run of an
+// FSM, that is, by reaching one of them and then going on to another.
+// Since a terminal state has no next states this can only happen through
+// the reopen transitions (see AddReopen) so those are followed, as well as
+// the ordinary transitions. Note that a state from which the paths branch
+// towards different terminal states of the group is not reported, as any
+// FSM in that state will still reach at most one of them. It returns an
+// error if any of the named states does not exist or is not a terminal
+// state. Otherwise, if any state can reach two or more of the terminal
+// states, an error is returned with one line for each such state, in the
+// order given by OrderedStates, naming the terminal states it can reach
+// in this way; if not, nil is returned.
+func (st StateTrans) AssertExclusiveTerminals(group ...string) error {
+	inGroup := map[string]bool{}
+	for _, name := range group {
+		s, err := st.getState(name)
+		if err != nil {
+			return err
+		}
+		if !s.isTerminal() {
+			return fmt.Errorf("%s: state: %q is not a terminal state",
+				st.name, s.name)
+		}
+		inGroup[s.name] = true
+	}
+
+	leadsTo := map[string][]string{}
+	for name := range inGroup {
+		for _, r := range sortedKeys(reachableReopening(st.states[name])) {
+			if inGroup[r] && r != name {
+				leadsTo[name] = append(leadsTo[name], r)
+			}
+		}
+	}
+
+	problems := []string{}
+	for _, name := range st.OrderedStates() {
+		reached := reachableReopening(st.states[name])
+		reached[name] = true
+
+		both := map[string]bool{}
+		for t, others := range leadsTo {
+			if !reached[t] {
+				continue
+			}
+			both[t] = true
+			for _, o := range others {
+				both[o] = true
+			}
+		}
+		if len(both) > 0 {
+			problems = append(problems,
+				fmt.Sprintf("%s: state: %q can reach more than one of the"+
+					" exclusive terminal states: %s",
+					st.name, name, strings.Join(sortedKeys(both), ", ")))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+// reachableReopening returns the set of states which can be reached from
+// the given state by some sequence of one or more transitions, including
+// reopen transitions.
+func reachableReopening(from *state) map[string]bool {
+	reached := map[string]bool{}
+	queue := []*state{from}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		for _, next := range []map[string]*state{s.nextState, s.reopenTo} {
+			for name, ns := range next {
+				if !reached[name] {
+					reached[name] = true
+					queue = append(queue, ns)
+				}
+			}
+		}
+	}
+
+	return reached
+}
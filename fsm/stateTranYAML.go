@@ -0,0 +1,35 @@
+package fsm
+
+import "gopkg.in/yaml.v3"
+
+// MarshalYAML returns a value which the yaml package will marshal into a
+// document giving the name of the StateTrans, its version (if set), a map
+// from each state to the sorted list of its next states and a map from
+// state to description. The other settings of the states and transitions,
+// such as aliases, locale descriptions, terminal classifications, reopen
+// transitions, rate limits and deprecations, are also given, as are any
+// unrecognised entries in the document from which the StateTrans was
+// unmarshalled. The guards, successor resolvers and instance tracking
+// cannot be recorded and so are not given. This satisfies the
+// yaml.Marshaler interface.
+func (st StateTrans) MarshalYAML() (any, error) {
+	return st.mkDoc(), nil
+}
+
+// UnmarshalYAML constructs a new StateTrans from the YAML document in
+// data. The document should have the form generated by
+// StateTrans.MarshalYAML. The same validation is applied as in
+// NewStateTrans and, additionally, every state given must be reachable
+// from the InitState and every described state must exist, as must every
+// state and transition given any other setting. Any entries which are not
+// recognised are kept and will be written out again by MarshalYAML. If
+// there are any problems a nil StateTrans and the error are returned.
+func UnmarshalYAML(data []byte) (*StateTrans, error) {
+	var doc stDoc
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc.stateTrans()
+}
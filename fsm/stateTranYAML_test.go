@@ -0,0 +1,362 @@
+package fsm_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+	"gopkg.in/yaml.v3"
+)
+
+func TestYAMLRoundTrip(t *testing.T) {
+	st, err := fsm.NewStateTrans("lifecycle",
+		fsm.STPair{fsm.InitState, "start"},
+		fsm.STPair{"start", "middle"},
+		fsm.STPair{"middle", "start"},
+		fsm.STPair{"middle", "finish"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.SetStateDesc("start", "the first state")
+
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		t.Fatal("couldn't marshal the StateTrans:", err)
+	}
+
+	expYAML := `name: lifecycle
+transitions:
+    finish: []
+    init:
+        - start
+    middle:
+        - finish
+        - start
+    start:
+        - middle
+descriptions:
+    init: the initial state
+    start: the first state
+`
+	testhelper.DiffString(t, "marshal", "YAML", string(data), expYAML)
+
+	newST, err := fsm.UnmarshalYAML(data)
+	if err != nil {
+		t.Fatal("couldn't unmarshal the StateTrans:", err)
+	}
+
+	var origDot, newDot bytes.Buffer
+	st.PrintDot(&origDot)
+	newST.PrintDot(&newDot)
+	testhelper.DiffString(t, "round trip", "DOT output",
+		newDot.String(), origDot.String())
+
+	newData, err := yaml.Marshal(newST)
+	if err != nil {
+		t.Fatal("couldn't re-marshal the StateTrans:", err)
+	}
+	testhelper.DiffString(t, "round trip", "YAML",
+		string(newData), string(data))
+}
+
+func TestYAMLRoundTripDetails(t *testing.T) {
+	doc := `name: review
+version: "2.1"
+transitions:
+    done: []
+    fix:
+        - review
+    init:
+        - review
+    review:
+        - done
+        - fix
+descriptions:
+    init: the initial state
+    review: under review
+groups:
+    done: closed
+meta:
+    review:
+        icon: eye
+costs:
+    review:
+        fix: 5
+events:
+    fix:
+        resubmit: review
+    review:
+        approve: done
+        reject: fix
+futureFeature:
+    enabled: true
+`
+	st, err := fsm.UnmarshalYAML([]byte(doc))
+	if err != nil {
+		t.Fatal("couldn't unmarshal the StateTrans:", err)
+	}
+
+	testhelper.DiffString(t, "unmarshal", "version", st.Version(), "2.1")
+	group, _ := st.StateGroup("done")
+	testhelper.DiffString(t, "unmarshal", "group", group, "closed")
+	meta, _ := st.StateMeta("review")
+	testhelper.DiffString(t, "unmarshal", "meta", meta["icon"], "eye")
+	_, cost, _ := st.CheapestPath(fsm.InitState, "fix")
+	testhelper.DiffInt(t, "unmarshal", "cost", cost, 6)
+	events, _ := st.EventsFrom("review")
+	testhelper.DiffStringSlice(t, "unmarshal", "events",
+		events, []string{"approve", "reject"})
+
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		t.Fatal("couldn't marshal the StateTrans:", err)
+	}
+	testhelper.DiffString(t, "round trip", "YAML", string(data), doc)
+
+	data, err = yaml.Marshal(st.Copy())
+	if err != nil {
+		t.Fatal("couldn't marshal the copied StateTrans:", err)
+	}
+	testhelper.DiffString(t, "copy", "YAML", string(data), doc)
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		doc            string
+		statesExpected []string
+	}{
+		{
+			ID: testhelper.MkID("good - unordered"),
+			doc: `name: test
+transitions:
+    b: [c]
+    a: [b]
+    init: [a]
+`,
+			statesExpected: []string{fsm.InitState, "a", "b", "c"},
+		},
+		{
+			ID: testhelper.MkID("bad - unreachable from state"),
+			doc: `name: test
+transitions:
+    init: [a]
+    x: [b]
+`,
+			ExpErr: testhelper.MkExpErr(
+				"test: state: 'x' does not exist", "failed"),
+		},
+		{
+			ID: testhelper.MkID("bad - unreachable terminal state"),
+			doc: `name: test
+transitions:
+    init: [a]
+    x: []
+`,
+			ExpErr: testhelper.MkExpErr(
+				`test: state: "x" cannot be reached from "init"`),
+		},
+		{
+			ID: testhelper.MkID("bad - described state doesn't exist"),
+			doc: `name: test
+transitions:
+    init: [a]
+descriptions:
+    b: a state
+`,
+			ExpErr: testhelper.MkExpErr(`test: state: "b" does not exist`),
+		},
+		{
+			ID: testhelper.MkID("bad - event without a transition"),
+			doc: `name: test
+transitions:
+    init: [a]
+    a: [b]
+events:
+    init:
+        skip: b
+`,
+			ExpErr: testhelper.MkExpErr(
+				`test: there is no transition from "init" to "b"`),
+		},
+		{
+			ID: testhelper.MkID("bad - rate limit not a duration"),
+			doc: `name: test
+transitions:
+    init: [a]
+rateLimits:
+    init:
+        a: soon
+`,
+			ExpErr: testhelper.MkExpErr(
+				`test: the rate limit of the transition from "init" to "a"` +
+					" is not valid"),
+		},
+		{
+			ID: testhelper.MkID("bad - success for a non-terminal state"),
+			doc: `name: test
+transitions:
+    init: [a]
+success:
+    init: true
+`,
+			ExpErr: testhelper.MkExpErr(
+				`test: state: "init" is not a terminal state`),
+		},
+		{
+			ID:     testhelper.MkID("bad - not YAML"),
+			doc:    "name: [",
+			ExpErr: testhelper.MkExpErr("yaml:"),
+		},
+	}
+
+	for _, tc := range testCases {
+		st, err := fsm.UnmarshalYAML([]byte(tc.doc))
+		if testhelper.CheckExpErr(t, err, tc) && err == nil {
+			testhelper.DiffInt(t, tc.IDStr(), "number of states",
+				st.StateCount(), len(tc.statesExpected))
+
+			for _, state := range tc.statesExpected {
+				if !st.HasState(state) {
+					t.Log(tc.IDStr())
+					t.Errorf("\t: state: %q was expected but not found", state)
+				}
+			}
+		}
+	}
+}
+
+func TestYAMLRoundTripSettings(t *testing.T) {
+	st, err := fsm.NewStateTransFoldCase("review",
+		fsm.STPair{fsm.InitState, "Open"},
+		fsm.STPair{"Open", "Queued"},
+		fsm.STPair{"Queued", "Review"},
+		fsm.STPair{"Review", "Done"},
+		fsm.STPair{"Review", "Rejected"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	for _, err := range []error{
+		st.AddAlias("Open", "new"),
+		st.SetStateDescLocale("Open", "fr", "ouvert"),
+		st.ClassifyTerminal("Done", true),
+		st.ClassifyTerminal("Rejected", false),
+		st.SetAutoAdvance("Queued"),
+		st.RequireVisited("Done", "Open"),
+		st.AddReopen("Rejected", "Review"),
+		st.SetRateLimit("Review", "Rejected", 90*time.Minute),
+		st.DeprecateTransition("Review", "Rejected", "use Done"),
+		st.ForbidTransition("Review", "Done"),
+		st.AddEntryGuard("Review",
+			func(_ *fsm.FSM) error { return errors.New("closed") }),
+		st.SetSuccessorResolver(fsm.InitState,
+			func(_ *fsm.FSM) []string { return []string{"Done"} }),
+	} {
+		if err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+	}
+	st.AddGlobalGuard(func(_ *fsm.FSM, _, _ string) error {
+		return errors.New("no changes allowed")
+	})
+
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		t.Fatal("couldn't marshal the StateTrans:", err)
+	}
+
+	expYAML := `name: review
+foldCase: true
+transitions:
+    Done: []
+    Open:
+        - Queued
+    Queued:
+        - Review
+    Rejected: []
+    Review:
+        - Done
+        - Rejected
+    init:
+        - Open
+reopen:
+    Rejected:
+        - Review
+descriptions:
+    init: the initial state
+locales:
+    Open:
+        fr: ouvert
+aliases:
+    new: Open
+success:
+    Done: true
+    Rejected: false
+autoAdvance:
+    - Queued
+requireVisited:
+    Done:
+        - Open
+rateLimits:
+    Review:
+        Rejected: 1h30m0s
+deprecated:
+    Review:
+        Rejected: use Done
+forbidden:
+    Review:
+        - Done
+`
+	testhelper.DiffString(t, "marshal", "YAML", string(data), expYAML)
+
+	newST, err := fsm.UnmarshalYAML(data)
+	if err != nil {
+		t.Fatal("couldn't unmarshal the StateTrans:", err)
+	}
+	newData, err := yaml.Marshal(newST)
+	if err != nil {
+		t.Fatal("couldn't re-marshal the StateTrans:", err)
+	}
+	testhelper.DiffString(t, "round trip", "YAML", string(newData), expYAML)
+
+	desc, _ := newST.StateDescLocale("open", "fr")
+	testhelper.DiffString(t, "round trip", "locale description", desc, "ouvert")
+
+	// The successor resolver, the guards and the entry guards are not kept
+	f := fsm.New(newST, nil)
+	testhelper.DiffStringSlice(t, "round trip", "next states",
+		f.NextStates(), []string{"Open"})
+	if err := f.ChangeState("NEW"); err != nil {
+		t.Fatal("round trip: unexpected error:", err)
+	}
+	testhelper.DiffString(t, "round trip", "alias",
+		f.CurrentState(), "Open")
+	if err := f.ChangeState("queued"); err != nil {
+		t.Fatal("round trip: unexpected error:", err)
+	}
+	testhelper.DiffString(t, "round trip", "auto-advance",
+		f.CurrentState(), "Review")
+
+	err = f.ChangeState("Done")
+	testhelper.CheckExpErrWithID(t, "round trip: forbidden", err,
+		testhelper.MkExpErr("the transition has been forbidden"))
+
+	if err := f.ChangeState("Rejected"); err != nil {
+		t.Fatal("round trip: unexpected error:", err)
+	}
+	success, isTerminal, classified := f.Outcome()
+	testhelper.DiffBool(t, "round trip", "success", success, false)
+	testhelper.DiffBool(t, "round trip", "terminal", isTerminal, true)
+	testhelper.DiffBool(t, "round trip", "classified", classified, true)
+
+	if err := f.Reopen("Review"); err != nil {
+		t.Fatal("round trip: unexpected error:", err)
+	}
+	err = f.ChangeState("Rejected")
+	testhelper.CheckExpErrWithID(t, "round trip: rate limited", err,
+		testhelper.MkExpErr(`"Review" to "Rejected"`))
+}
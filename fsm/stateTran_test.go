@@ -0,0 +1,1631 @@
+package fsm_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nickwells/fsm.mod/fsm"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestGroupStates(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		states         []fsm.STPair
+		expNonTerminal []string
+		expTerminal    []string
+	}{
+		{
+			ID:             testhelper.MkID("no transitions"),
+			expNonTerminal: []string{},
+			expTerminal:    []string{fsm.InitState},
+		},
+		{
+			ID: testhelper.MkID("multi transition"),
+			states: []fsm.STPair{
+				{fsm.InitState, "B"},
+				{fsm.InitState, "A"},
+				{"A", "D"},
+				{"A", "C"},
+			},
+			expNonTerminal: []string{"A", fsm.InitState},
+			expTerminal:    []string{"B", "C", "D"},
+		},
+	}
+
+	for _, tc := range testCases {
+		st, err := fsm.NewStateTrans("testStateTrans", tc.states...)
+		if err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+		nonTerminal, terminal := st.GroupStates()
+		testhelper.DiffStringSlice(t, tc.IDStr(), "non-terminal states",
+			nonTerminal, tc.expNonTerminal)
+		testhelper.DiffStringSlice(t, tc.IDStr(), "terminal states",
+			terminal, tc.expTerminal)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	mkST := func(name string, desc string, transitions ...fsm.STPair,
+	) *fsm.StateTrans {
+		t.Helper()
+		st, err := fsm.NewStateTrans(name, transitions...)
+		if err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+		if desc != "" {
+			_ = st.SetStateDesc("A", desc)
+		}
+		return st
+	}
+
+	base := mkST("base", "",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{"A", "C"})
+
+	testCases := []struct {
+		testhelper.ID
+		st       *fsm.StateTrans
+		expEqual bool
+	}{
+		{
+			ID: testhelper.MkID("same - different order and name"),
+			st: mkST("other", "",
+				fsm.STPair{fsm.InitState, "B"},
+				fsm.STPair{fsm.InitState, "A"},
+				fsm.STPair{"A", "C"}),
+			expEqual: true,
+		},
+		{
+			ID: testhelper.MkID("different - description"),
+			st: mkST("base", "a description",
+				fsm.STPair{fsm.InitState, "A"},
+				fsm.STPair{fsm.InitState, "B"},
+				fsm.STPair{"A", "C"}),
+		},
+		{
+			ID: testhelper.MkID("different - transition"),
+			st: mkST("base", "",
+				fsm.STPair{fsm.InitState, "A"},
+				fsm.STPair{fsm.InitState, "B"},
+				fsm.STPair{"B", "C"}),
+		},
+	}
+
+	for _, tc := range testCases {
+		testhelper.DiffBool(t, tc.IDStr(), "fingerprints equal",
+			tc.st.Fingerprint() == base.Fingerprint(), tc.expEqual)
+	}
+}
+
+func TestVersionedFingerprint(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	testhelper.DiffString(t, "no version", "version", st.Version(), "")
+
+	fp, vfp := st.Fingerprint(), st.VersionedFingerprint()
+	st.SetVersion("2")
+	testhelper.DiffString(t, "versioned", "version", st.Version(), "2")
+	testhelper.DiffString(t, "versioned", "fingerprint", st.Fingerprint(), fp)
+	testhelper.DiffBool(t, "versioned", "versioned fingerprint changed",
+		st.VersionedFingerprint() != vfp, true)
+	testhelper.DiffString(t, "copy", "versioned fingerprint",
+		st.Copy().VersionedFingerprint(), st.VersionedFingerprint())
+}
+
+func TestPathsToTerminals(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "A"},
+		fsm.STPair{"A", "Y"},
+		fsm.STPair{"B", "Z"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	allPaths := [][]string{
+		{fsm.InitState, "A", "B", "Z"},
+		{fsm.InitState, "A", "Y"},
+		{fsm.InitState, "B", "A", "Y"},
+		{fsm.InitState, "B", "Z"},
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		maxPaths int
+		expPaths [][]string
+	}{
+		{
+			ID:       testhelper.MkID("no limit"),
+			expPaths: allPaths,
+		},
+		{
+			ID:       testhelper.MkID("limit above path count"),
+			maxPaths: 10,
+			expPaths: allPaths,
+		},
+		{
+			ID:       testhelper.MkID("limited"),
+			maxPaths: 3,
+			expPaths: allPaths[:3],
+		},
+	}
+
+	for _, tc := range testCases {
+		paths := st.PathsToTerminals(tc.maxPaths)
+		if err := testhelper.DiffVals(paths, tc.expPaths); err != nil {
+			t.Log(tc.IDStr())
+			t.Errorf("\t: paths: %s", err)
+		}
+	}
+}
+
+func TestNewStateTransFoldCase(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		states []fsm.STPair
+	}{
+		{
+			ID: testhelper.MkID("good"),
+			states: []fsm.STPair{
+				{fsm.InitState, "Rejected"},
+				{fsm.InitState, "Accepted"},
+			},
+		},
+		{
+			ID: testhelper.MkID("bad - clashing 'to' states"),
+			states: []fsm.STPair{
+				{fsm.InitState, "Rejected"},
+				{fsm.InitState, "rejected"},
+			},
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "rejected" clashes with` +
+					` state "Rejected" (case is ignored)`),
+		},
+		{
+			ID: testhelper.MkID("bad - clashing 'from' state"),
+			states: []fsm.STPair{
+				{"INIT", "Rejected"},
+			},
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "INIT" clashes with` +
+					` state "init" (case is ignored)`),
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := fsm.NewStateTransFoldCase("testStateTrans", tc.states...)
+		testhelper.CheckExpErr(t, err, tc)
+	}
+
+	st, err := fsm.NewStateTransFoldCase("testStateTrans",
+		fsm.STPair{fsm.InitState, "Rejected"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	testhelper.DiffBool(t, "fold case", "HasState(REJECTED)",
+		st.HasState("REJECTED"), true)
+	if err := st.SetStateDesc("rejected", "not wanted"); err != nil {
+		t.Error("unexpected error setting the description:", err)
+	}
+
+	f := fsm.New(st, nil)
+	if err := f.ChangeState("rejected"); err != nil {
+		t.Error("unexpected error changing state:", err)
+	}
+	testhelper.DiffString(t, "fold case", "current state",
+		f.CurrentState(), "Rejected")
+	testhelper.DiffString(t, "fold case", "formatted state",
+		fmt.Sprintf("%#s", f),
+		"testStateTrans: Rejected [not wanted]"+
+			" (was: init [the initial state])")
+
+	exact, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "Rejected"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	testhelper.DiffBool(t, "exact case", "HasState(REJECTED)",
+		exact.HasState("REJECTED"), false)
+}
+
+func TestDiff(t *testing.T) {
+	st1, err := fsm.NewStateTrans("v1",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"A", "C"},
+		fsm.STPair{"B", "D"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	st2, err := fsm.NewStateTrans("v2",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "C"},
+		fsm.STPair{"B", "E"},
+		fsm.STPair{"A", "E"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		a, b    *fsm.StateTrans
+		expDiff fsm.StateTransDiff
+	}{
+		{
+			ID: testhelper.MkID("v1 to v2"),
+			a:  st1,
+			b:  st2,
+			expDiff: fsm.StateTransDiff{
+				AddedStates:   []string{"E"},
+				RemovedStates: []string{"D"},
+				AddedTransitions: []fsm.STPair{
+					{"A", "E"},
+					{"B", "C"},
+					{"B", "E"},
+				},
+				RemovedTransitions: []fsm.STPair{
+					{"A", "C"},
+					{"B", "D"},
+				},
+			},
+		},
+		{
+			ID: testhelper.MkID("v2 to v1"),
+			a:  st2,
+			b:  st1,
+			expDiff: fsm.StateTransDiff{
+				AddedStates:   []string{"D"},
+				RemovedStates: []string{"E"},
+				AddedTransitions: []fsm.STPair{
+					{"A", "C"},
+					{"B", "D"},
+				},
+				RemovedTransitions: []fsm.STPair{
+					{"A", "E"},
+					{"B", "C"},
+					{"B", "E"},
+				},
+			},
+		},
+		{
+			ID: testhelper.MkID("no change"),
+			a:  st1,
+			b:  st1,
+			expDiff: fsm.StateTransDiff{
+				AddedStates:        []string{},
+				RemovedStates:      []string{},
+				AddedTransitions:   []fsm.STPair{},
+				RemovedTransitions: []fsm.STPair{},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		diff := tc.a.Diff(tc.b)
+		if err := testhelper.DiffVals(diff, tc.expDiff); err != nil {
+			t.Log(tc.IDStr())
+			t.Errorf("\t: diff: %s", err)
+		}
+		testhelper.DiffBool(t, tc.IDStr(), "IsEmpty",
+			diff.IsEmpty(), tc.a == tc.b)
+	}
+}
+
+func TestSuccessors(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{"A", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.SetStateDesc("A", "state A")
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		name    string
+		expSucc map[string]string
+	}{
+		{
+			ID:      testhelper.MkID("init"),
+			name:    fsm.InitState,
+			expSucc: map[string]string{"A": "state A", "B": ""},
+		},
+		{
+			ID:      testhelper.MkID("terminal"),
+			name:    "C",
+			expSucc: map[string]string{},
+		},
+		{
+			ID:   testhelper.MkID("unknown state"),
+			name: "nonesuch",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "nonesuch" does not exist`),
+		},
+	}
+
+	for _, tc := range testCases {
+		succ, err := st.Successors(tc.name)
+		if testhelper.CheckExpErr(t, err, tc) && err == nil {
+			if err := testhelper.DiffVals(succ, tc.expSucc); err != nil {
+				t.Log(tc.IDStr())
+				t.Errorf("\t: successors: %s", err)
+			}
+		}
+	}
+
+	succ, _ := st.Successors(fsm.InitState)
+	succ["A"] = "changed"
+	delete(succ, "B")
+	succ, _ = st.Successors(fsm.InitState)
+	if err := testhelper.DiffVals(succ,
+		map[string]string{"A": "state A", "B": ""}); err != nil {
+		t.Errorf("successors after changing the copy: %s", err)
+	}
+}
+
+func TestNewStateTransClosed(t *testing.T) {
+	allowed := []string{"A", "B"}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		states []fsm.STPair
+	}{
+		{
+			ID: testhelper.MkID("good"),
+			states: []fsm.STPair{
+				{fsm.InitState, "A"},
+				{"A", "B"},
+			},
+		},
+		{
+			ID: testhelper.MkID("bad - 'to' state not allowed"),
+			states: []fsm.STPair{
+				{fsm.InitState, "A"},
+				{"A", "b"},
+			},
+			ExpErr: testhelper.MkExpErr(
+				"testStateTrans: state: 'b' is not allowed", "failed"),
+		},
+		{
+			ID: testhelper.MkID("bad - 'from' state not allowed"),
+			states: []fsm.STPair{
+				{"X", "A"},
+			},
+			ExpErr: testhelper.MkExpErr(
+				"testStateTrans: state: 'X' is not allowed", "failed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := fsm.NewStateTransClosed("testStateTrans", allowed,
+			tc.states...)
+		testhelper.CheckExpErr(t, err, tc)
+	}
+}
+
+func TestStateDescLocale(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.SetStateDesc("A", "the first state")
+	if err := st.SetStateDescLocale("A", "fr", "le premier état"); err != nil {
+		t.Fatal("couldn't set the locale description:", err)
+	}
+	err = st.SetStateDescLocale("nonesuch", "fr", "inconnu")
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(
+			`testStateTrans: state: "nonesuch" does not exist`))
+
+	testCases := []struct {
+		testhelper.ID
+		name, locale string
+		expDesc      string
+		expFound     bool
+	}{
+		{
+			ID:       testhelper.MkID("locale set"),
+			name:     "A",
+			locale:   "fr",
+			expDesc:  "le premier état",
+			expFound: true,
+		},
+		{
+			ID:      testhelper.MkID("locale not set"),
+			name:    "A",
+			locale:  "de",
+			expDesc: "the first state",
+		},
+		{
+			ID:     testhelper.MkID("unknown state"),
+			name:   "nonesuch",
+			locale: "fr",
+		},
+	}
+
+	for _, tc := range testCases {
+		desc, found := st.StateDescLocale(tc.name, tc.locale)
+		testhelper.DiffString(t, tc.IDStr(), "description", desc, tc.expDesc)
+		testhelper.DiffBool(t, tc.IDStr(), "found", found, tc.expFound)
+	}
+}
+
+func TestLongestPathToTerminal(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		states  []fsm.STPair
+		expPath []string
+	}{
+		{
+			ID:      testhelper.MkID("no transitions"),
+			expPath: []string{fsm.InitState},
+		},
+		{
+			ID: testhelper.MkID("with cycles"),
+			states: []fsm.STPair{
+				{fsm.InitState, "A"},
+				{fsm.InitState, "Z"},
+				{"A", "B"},
+				{"B", "A"},
+				{"B", "C"},
+				{"C", "Z"},
+				{"A", "Z"},
+			},
+			expPath: []string{fsm.InitState, "A", "B", "C", "Z"},
+		},
+		{
+			ID: testhelper.MkID("no terminal"),
+			states: []fsm.STPair{
+				{fsm.InitState, "A"},
+				{"A", fsm.InitState},
+			},
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: no terminal state can be reached from "init"`),
+		},
+	}
+
+	for _, tc := range testCases {
+		st, err := fsm.NewStateTrans("testStateTrans", tc.states...)
+		if err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+		path, err := st.LongestPathToTerminal()
+		if testhelper.CheckExpErr(t, err, tc) && err == nil {
+			testhelper.DiffStringSlice(t, tc.IDStr(), "path",
+				path, tc.expPath)
+		}
+	}
+}
+
+// mkBenchTransitions returns a large set of transitions for benchmarking
+func mkBenchTransitions() []fsm.STPair {
+	const (
+		stateCount = 200
+		nextCount  = 20
+	)
+
+	transitions := []fsm.STPair{{fsm.InitState, "S0"}}
+	for i := 0; i < stateCount; i++ {
+		from := fmt.Sprintf("S%d", i)
+		for j := 1; j <= nextCount; j++ {
+			transitions = append(transitions,
+				fsm.STPair{from, fmt.Sprintf("S%d", (i+j)%stateCount)})
+		}
+	}
+	return transitions
+}
+
+func TestNewStateTransCap(t *testing.T) {
+	transitions := mkBenchTransitions()
+
+	st, err := fsm.NewStateTrans("testStateTrans", transitions...)
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	stCap, err := fsm.NewStateTransCap("testStateTrans", 201, transitions...)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	testhelper.DiffBool(t, "NewStateTransCap", "same as NewStateTrans",
+		st.Diff(stCap).IsEmpty(), true)
+
+	_, err = fsm.NewStateTransCap("testStateTrans", 10,
+		fsm.STPair{"X", "A"})
+	testhelper.CheckExpErrWithID(t, "NewStateTransCap", err,
+		testhelper.MkExpErr(
+			"testStateTrans: state: 'X' does not exist", "failed"))
+}
+
+func BenchmarkNewStateTrans(b *testing.B) {
+	transitions := mkBenchTransitions()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = fsm.NewStateTrans("bench", transitions...)
+	}
+}
+
+func BenchmarkNewStateTransCap(b *testing.B) {
+	transitions := mkBenchTransitions()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = fsm.NewStateTransCap("bench", 201, transitions...)
+	}
+}
+
+func TestIsWeaklyConnected(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{fsm.InitState, "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	testhelper.DiffBool(t, "connected", "weakly connected",
+		st.IsWeaklyConnected(), true)
+
+	st, err = fsm.NewStateTrans("testStateTrans")
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	testhelper.DiffBool(t, "single state", "weakly connected",
+		st.IsWeaklyConnected(), true)
+
+	st, err = fsm.NewStateTransAuto("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"X", "Y"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	testhelper.DiffBool(t, "disconnected", "weakly connected",
+		st.IsWeaklyConnected(), false)
+}
+
+func TestNewStateTransAuto(t *testing.T) {
+	st, err := fsm.NewStateTransAuto("testStateTrans",
+		fsm.STPair{"B", "C"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"C", "A"})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	testhelper.DiffStringSlice(t, "unordered", "states",
+		st.OrderedStates(), []string{fsm.InitState, "A", "B", "C"})
+	testhelper.DiffInt(t, "unordered", "transitions",
+		st.TransitionCount(), 4)
+
+	succ, err := st.Successors("B")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, ok := succ["C"]; !ok || len(succ) != 1 {
+		t.Errorf("unordered: the successors of B should be just C, got: %v",
+			succ)
+	}
+
+	_, err = fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{"B", "C"},
+		fsm.STPair{fsm.InitState, "B"})
+	testhelper.CheckExpErrWithID(t, "strict", err,
+		testhelper.MkExpErr("testStateTrans: state: 'B' does not exist"))
+}
+
+func TestFindByDesc(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.SetDescriptions(
+		fsm.StateDesc{Name: "A", Desc: "Waiting for Payment"},
+		fsm.StateDesc{Name: "B", Desc: "payment received"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		substr string
+		expect []string
+	}{
+		{
+			ID:     testhelper.MkID("mixed case match"),
+			substr: "PAYMENT",
+			expect: []string{"A", "B"},
+		},
+		{
+			ID:     testhelper.MkID("single match"),
+			substr: "initial",
+			expect: []string{fsm.InitState},
+		},
+		{
+			ID:     testhelper.MkID("no match"),
+			substr: "nonesuch",
+			expect: []string{},
+		},
+		{
+			ID:     testhelper.MkID("empty - all described states"),
+			substr: "",
+			expect: []string{"A", "B", fsm.InitState},
+		},
+	}
+
+	for _, tc := range testCases {
+		testhelper.DiffStringSlice(t, tc.IDStr(), "states",
+			st.FindByDesc(tc.substr), tc.expect)
+	}
+}
+
+func TestAreAdjacent(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"},
+		fsm.STPair{"B", "B"},
+		fsm.STPair{"B", "C"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		a, b   string
+		expAdj bool
+	}{
+		{
+			ID:     testhelper.MkID("forwards"),
+			a:      "A",
+			b:      "B",
+			expAdj: true,
+		},
+		{
+			ID:     testhelper.MkID("backwards"),
+			a:      "C",
+			b:      "B",
+			expAdj: true,
+		},
+		{
+			ID: testhelper.MkID("not adjacent"),
+			a:  fsm.InitState,
+			b:  "C",
+		},
+		{
+			ID:     testhelper.MkID("self - loop"),
+			a:      "B",
+			b:      "B",
+			expAdj: true,
+		},
+		{
+			ID: testhelper.MkID("self - no loop"),
+			a:  "A",
+			b:  "A",
+		},
+		{
+			ID: testhelper.MkID("unknown a"),
+			a:  "nonesuch",
+			b:  "A",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "nonesuch" does not exist`),
+		},
+		{
+			ID: testhelper.MkID("unknown b"),
+			a:  "A",
+			b:  "nonesuch",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "nonesuch" does not exist`),
+		},
+	}
+
+	for _, tc := range testCases {
+		adj, err := st.AreAdjacent(tc.a, tc.b)
+		if testhelper.CheckExpErr(t, err, tc) && err == nil {
+			testhelper.DiffBool(t, tc.IDStr(), "adjacent", adj, tc.expAdj)
+		}
+	}
+}
+
+func TestCanRestore(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	if err = st.AddReopen("B", fsm.InitState); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		current, prior string
+	}{
+		{
+			ID:      testhelper.MkID("new FSM"),
+			current: fsm.InitState,
+			prior:   fsm.InitState,
+		},
+		{
+			ID:      testhelper.MkID("valid transition"),
+			current: "B",
+			prior:   "A",
+		},
+		{
+			ID:      testhelper.MkID("reopen transition"),
+			current: fsm.InitState,
+			prior:   "B",
+		},
+		{
+			ID:      testhelper.MkID("no transition"),
+			current: "A",
+			prior:   "B",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: there is no transition from "B" to "A"`),
+		},
+		{
+			ID:      testhelper.MkID("same state - not init"),
+			current: "A",
+			prior:   "A",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: there is no transition from "A" to "A"`),
+		},
+		{
+			ID:      testhelper.MkID("unknown current"),
+			current: "removed",
+			prior:   "A",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "removed" does not exist`),
+		},
+		{
+			ID:      testhelper.MkID("unknown prior"),
+			current: "B",
+			prior:   "renamed",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "renamed" does not exist`),
+		},
+	}
+
+	for _, tc := range testCases {
+		err := st.CanRestore(tc.current, tc.prior)
+		testhelper.CheckExpErr(t, err, tc)
+	}
+}
+
+func TestStateMeta(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	meta := map[string]string{"icon": "star", "color": "red"}
+	if err = st.SetStateMeta("A", meta); err != nil {
+		t.Fatal("couldn't set the metadata:", err)
+	}
+	meta["icon"] = "changed"
+
+	err = st.SetStateMeta("nonesuch", meta)
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(
+			`testStateTrans: state: "nonesuch" does not exist`))
+
+	got, ok := st.StateMeta("A")
+	testhelper.DiffBool(t, "set", "found", ok, true)
+	if err := testhelper.DiffVals(got,
+		map[string]string{"icon": "star", "color": "red"}); err != nil {
+		t.Log("set")
+		t.Errorf("\t: metadata: %s", err)
+	}
+
+	got["icon"] = "changed"
+	got, _ = st.StateMeta("A")
+	testhelper.DiffString(t, "copy changed", "icon", got["icon"], "star")
+
+	_, ok = st.StateMeta(fsm.InitState)
+	testhelper.DiffBool(t, "not set", "found", ok, false)
+	_, ok = st.StateMeta("nonesuch")
+	testhelper.DiffBool(t, "unknown state", "found", ok, false)
+
+	if err = st.SetStateMeta("A", nil); err != nil {
+		t.Fatal("couldn't clear the metadata:", err)
+	}
+	_, ok = st.StateMeta("A")
+	testhelper.DiffBool(t, "cleared", "found", ok, false)
+}
+
+func TestValidateStrict(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "rejected"},
+		fsm.STPair{"A", "done"},
+		fsm.STPair{"A", "abandoned"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	const noDesc = " has no description"
+	err = st.ValidateStrict()
+	testhelper.CheckExpErrWithID(t, "undescribed", err,
+		testhelper.MkExpErr(
+			`testStateTrans: terminal state: "abandoned"`+noDesc+"\n"+
+				`testStateTrans: terminal state: "done"`+noDesc+"\n"+
+				`testStateTrans: terminal state: "rejected"`+noDesc))
+
+	err = st.SetDescriptions(
+		fsm.StateDesc{Name: "abandoned", Desc: "given up"},
+		fsm.StateDesc{Name: "done", Desc: "completed"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.ValidateStrict()
+	testhelper.CheckExpErrWithID(t, "one undescribed", err,
+		testhelper.MkExpErr(
+			`testStateTrans: terminal state: "rejected"`+noDesc))
+	if err != nil && strings.Contains(err.Error(), "done") {
+		t.Errorf("one undescribed: unexpected error: %s", err)
+	}
+
+	_ = st.SetStateDesc("rejected", "turned down")
+	err = st.ValidateStrict()
+	testhelper.CheckExpErrWithID(t, "all described", err,
+		testhelper.ExpErr{})
+}
+
+func TestEquivalentStateGroups(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		trans  []fsm.STPair
+		expect [][]string
+	}{
+		{
+			ID: testhelper.MkID("no equivalent states"),
+			trans: []fsm.STPair{
+				{From: fsm.InitState, To: "A"},
+				{From: "A", To: "B"},
+			},
+			expect: [][]string{},
+		},
+		{
+			ID: testhelper.MkID("equivalent states"),
+			trans: []fsm.STPair{
+				{From: fsm.InitState, To: "B"},
+				{From: fsm.InitState, To: "A"},
+				{From: "B", To: "C"},
+				{From: "B", To: "D"},
+				{From: "A", To: "D"},
+				{From: "A", To: "C"},
+				{From: "C", To: "E"},
+				{From: "D", To: "F"},
+			},
+			expect: [][]string{
+				{"A", "B"},
+				{"E", "F"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		st, err := fsm.NewStateTrans("testStateTrans", tc.trans...)
+		if err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+		got := st.EquivalentStateGroups()
+		if err := testhelper.DiffVals(got, tc.expect); err != nil {
+			t.Log(tc.IDStr())
+			t.Errorf("\t: groups: %s", err)
+		}
+	}
+}
+
+func TestAddAlias(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "UnderReview"},
+		fsm.STPair{"UnderReview", "Done"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		canonical, alias string
+	}{
+		{
+			ID:        testhelper.MkID("good"),
+			canonical: "UnderReview",
+			alias:     "IN_REVIEW",
+		},
+		{
+			ID:        testhelper.MkID("good - via an alias"),
+			canonical: "IN_REVIEW",
+			alias:     "reviewing",
+		},
+		{
+			ID:        testhelper.MkID("bad - unknown state"),
+			canonical: "nonesuch",
+			alias:     "other",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "nonesuch" does not exist`),
+		},
+		{
+			ID:        testhelper.MkID("bad - clashes with a state"),
+			canonical: "UnderReview",
+			alias:     "Done",
+			ExpErr: testhelper.MkExpErr(`testStateTrans: alias: "Done"` +
+				" clashes with an existing state or alias"),
+		},
+		{
+			ID:        testhelper.MkID("bad - clashes with an alias"),
+			canonical: "Done",
+			alias:     "IN_REVIEW",
+			ExpErr: testhelper.MkExpErr(`testStateTrans: alias: "IN_REVIEW"` +
+				" clashes with an existing state or alias"),
+		},
+	}
+
+	for _, tc := range testCases {
+		err := st.AddAlias(tc.canonical, tc.alias)
+		testhelper.CheckExpErr(t, err, tc)
+	}
+
+	testhelper.DiffBool(t, "alias", "HasState", st.HasState("reviewing"), true)
+	if err = st.SetStateDesc("IN_REVIEW", "being reviewed"); err != nil {
+		t.Error("SetStateDesc via an alias: unexpected error:", err)
+	}
+	testhelper.DiffStringSlice(t, "alias", "states",
+		st.OrderedStates(), []string{fsm.InitState, "Done", "UnderReview"})
+
+	f := fsm.New(st, nil)
+	if err = f.ChangeState("IN_REVIEW"); err != nil {
+		t.Error("ChangeState via an alias: unexpected error:", err)
+	}
+	testhelper.DiffString(t, "alias", "current state",
+		f.CurrentState(), "UnderReview")
+	testhelper.DiffStringSlice(t, "alias", "described states",
+		st.FindByDesc("reviewed"), []string{"UnderReview"})
+}
+
+func TestBidirectionalPairs(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "ReadyToReview"},
+		fsm.STPair{"ReadyToReview", "UnderReview"},
+		fsm.STPair{"UnderReview", "ReadyToReview"},
+		fsm.STPair{"UnderReview", "ReadyToFix"},
+		fsm.STPair{"ReadyToFix", "UnderReview"},
+		fsm.STPair{"ReadyToFix", "ReadyToFix"},
+		fsm.STPair{"UnderReview", "Rejected"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testhelper.DiffSlice(t, "review workflow", "pairs",
+		st.BidirectionalPairs(), []fsm.STPair{
+			{From: "ReadyToFix", To: "UnderReview"},
+			{From: "ReadyToReview", To: "UnderReview"},
+		})
+
+	st, err = fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	testhelper.DiffSlice(t, "no pairs", "pairs",
+		st.BidirectionalPairs(), []fsm.STPair{})
+}
+
+func TestStatesByDegree(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "ReadyToReview"},
+		fsm.STPair{"ReadyToReview", "UnderReview"},
+		fsm.STPair{"UnderReview", "ReadyToReview"},
+		fsm.STPair{"UnderReview", "ReadyToFix"},
+		fsm.STPair{"ReadyToFix", "UnderReview"},
+		fsm.STPair{"ReadyToFix", "ReadyToFix"},
+		fsm.STPair{"UnderReview", "Rejected"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	if err := testhelper.DiffVals(st.Degrees(), map[string]fsm.Degree{
+		fsm.InitState:   {In: 0, Out: 1},
+		"ReadyToReview": {In: 2, Out: 1},
+		"UnderReview":   {In: 2, Out: 3},
+		"ReadyToFix":    {In: 2, Out: 2},
+		"Rejected":      {In: 1, Out: 0},
+	}); err != nil {
+		t.Errorf("unexpected degrees: %s", err)
+	}
+
+	testhelper.DiffStringSlice(t, "review workflow", "states",
+		st.StatesByDegree(), []string{
+			"UnderReview",
+			"ReadyToFix",
+			"ReadyToReview",
+			"Rejected",
+			fsm.InitState,
+		})
+}
+
+func TestGuardedReachability(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "draft"},
+		fsm.STPair{"draft", "review"},
+		fsm.STPair{"review", "published"},
+		fsm.STPair{"draft", "archived"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	reviewers := 0
+	err = st.AddPureEntryGuard("review", func(_ *fsm.FSM) error {
+		if reviewers == 0 {
+			return errors.New("there are no reviewers")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.AddEntryGuard("archived", func(_ *fsm.FSM) error {
+		return errors.New("impure guards are ignored")
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	publishing := false
+	st.AddPureGlobalGuard(func(_ *fsm.FSM, _, to string) error {
+		if to == "published" && !publishing {
+			return errors.New("publishing is switched off")
+		}
+		return nil
+	})
+	err = st.AddPureEntryGuard("nonesuch", func(_ *fsm.FSM) error {
+		return nil
+	})
+	testhelper.CheckExpErrWithID(t, "unknown state", err,
+		testhelper.MkExpErr(`testStateTrans: state: "nonesuch" does not exist`))
+
+	f := fsm.New(st, nil)
+	checkGuardedReachability := func(id string, exp []string) {
+		t.Helper()
+		unreachable, err := st.GuardedReachability(f)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			return
+		}
+		testhelper.DiffStringSlice(t, id, "unreachable", unreachable, exp)
+	}
+	checkGuardedReachability("no reviewers", []string{"published", "review"})
+
+	reviewers = 1
+	checkGuardedReachability("not publishing", []string{"published"})
+
+	publishing = true
+	checkGuardedReachability("all allowed", []string{})
+
+	if err := f.ChangeState("draft"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	checkGuardedReachability("from draft", []string{fsm.InitState})
+
+	otherST, err := fsm.NewStateTrans("other",
+		fsm.STPair{fsm.InitState, "elsewhere"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	other := fsm.New(otherST, nil)
+	if err := other.ChangeState("elsewhere"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	unreachable, err := st.GuardedReachability(other)
+	testhelper.CheckExpErrWithID(t, "other StateTrans", err,
+		testhelper.MkExpErr(
+			`testStateTrans: state: "elsewhere" does not exist`))
+	testhelper.DiffStringSlice(t, "other StateTrans", "unreachable",
+		unreachable, nil)
+}
+
+func TestGuardIntrospection(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.AddEntryGuard("B", func(_ *fsm.FSM) error { return nil })
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	err = st.SetSuccessorResolver("A", func(_ *fsm.FSM) []string {
+		return nil
+	})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	check := func(id, name string, got, want bool) {
+		t.Helper()
+		testhelper.DiffBool(t, id, name, got, want)
+	}
+
+	check("entry guard", "init->A guarded", st.HasGuard(fsm.InitState, "A"),
+		false)
+	check("entry guard", "A->B guarded", st.HasGuard("A", "B"), true)
+	check("entry guard", "B->A guarded", st.HasGuard("B", "A"), false)
+	check("entry guard", "A has entry guard", st.HasEntryGuard("A"), false)
+	check("entry guard", "B has entry guard", st.HasEntryGuard("B"), true)
+	check("entry guard", "unknown has entry guard",
+		st.HasEntryGuard("nonesuch"), false)
+
+	check("resolver", "A has resolver", st.HasSuccessorResolver("A"), true)
+	check("resolver", "B has resolver", st.HasSuccessorResolver("B"), false)
+	check("resolver", "unknown has resolver",
+		st.HasSuccessorResolver("nonesuch"), false)
+
+	st.AddGlobalGuard(func(_ *fsm.FSM, _, _ string) error { return nil })
+	check("global guard", "init->A guarded", st.HasGuard(fsm.InitState, "A"),
+		true)
+	check("global guard", "B->A guarded", st.HasGuard("B", "A"), false)
+}
+
+func TestCheapestPath(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "manual"},
+		fsm.STPair{fsm.InitState, "auto1"},
+		fsm.STPair{"manual", "done"},
+		fsm.STPair{"auto1", "auto2"},
+		fsm.STPair{"auto2", "done"},
+		fsm.STPair{"done", "archived"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		costs    []fsm.STPair
+		from, to string
+		expPath  []string
+		expCost  int
+	}{
+		{
+			ID:      testhelper.MkID("default costs"),
+			from:    fsm.InitState,
+			to:      "done",
+			expPath: []string{fsm.InitState, "manual", "done"},
+			expCost: 2,
+		},
+		{
+			ID:    testhelper.MkID("manual step is expensive"),
+			costs: []fsm.STPair{{From: fsm.InitState, To: "manual"}},
+			from:  fsm.InitState,
+			to:    "archived",
+			expPath: []string{
+				fsm.InitState, "auto1", "auto2", "done", "archived",
+			},
+			expCost: 4,
+		},
+		{
+			ID:      testhelper.MkID("same state"),
+			from:    "done",
+			to:      "done",
+			expPath: []string{"done"},
+		},
+		{
+			ID:   testhelper.MkID("no path"),
+			from: "done",
+			to:   "manual",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: there is no path from "done" to "manual"`),
+		},
+		{
+			ID:   testhelper.MkID("unknown state"),
+			from: fsm.InitState,
+			to:   "nonesuch",
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "nonesuch" does not exist`),
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, stp := range tc.costs {
+			if err := st.SetTransitionCost(stp.From, stp.To, 10); err != nil {
+				t.Fatal("couldn't setup the test:", err)
+			}
+		}
+		path, cost, err := st.CheapestPath(tc.from, tc.to)
+		if testhelper.CheckExpErr(t, err, tc) && err == nil {
+			testhelper.DiffStringSlice(t, tc.IDStr(), "path", path, tc.expPath)
+			testhelper.DiffInt(t, tc.IDStr(), "cost", cost, tc.expCost)
+		}
+	}
+
+	err = st.SetTransitionCost("done", "manual", 1)
+	testhelper.CheckExpErrWithID(t, "no transition", err,
+		testhelper.MkExpErr(
+			`testStateTrans: there is no transition from "done" to "manual"`))
+	err = st.SetTransitionCost("done", "archived", -1)
+	testhelper.CheckExpErrWithID(t, "negative cost", err,
+		testhelper.MkExpErr("must not be negative: -1"))
+}
+
+func TestCopy(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	_ = st.SetStateDesc("A", "the first state")
+	_ = st.SetStateMeta("A", map[string]string{"icon": "star"})
+	_ = st.SetStateGroup("B", "finished")
+	_ = st.AddAlias("A", "first")
+
+	var origDot bytes.Buffer
+	st.PrintDot(&origDot)
+	origFP := st.Fingerprint()
+
+	c := st.Copy()
+
+	var copyDot bytes.Buffer
+	c.PrintDot(&copyDot)
+	testhelper.DiffString(t, "copied", "DOT output",
+		copyDot.String(), origDot.String())
+	testhelper.DiffString(t, "copied", "fingerprint", c.Fingerprint(), origFP)
+	testhelper.DiffBool(t, "copied", "alias", c.HasState("first"), true)
+
+	_ = c.SetStateDesc("A", "changed")
+	_ = c.SetStateMeta("A", map[string]string{"icon": "moon"})
+	_ = c.SetStateGroup("B", "")
+	_ = c.SetTransitionCost("A", "B", 5)
+	c.AddGlobalGuard(func(_ *fsm.FSM, _, _ string) error {
+		return errors.New("copy guard")
+	})
+
+	testhelper.DiffString(t, "original", "fingerprint",
+		st.Fingerprint(), origFP)
+	var afterDot bytes.Buffer
+	st.PrintDot(&afterDot)
+	testhelper.DiffString(t, "original", "DOT output",
+		afterDot.String(), origDot.String())
+	meta, _ := st.StateMeta("A")
+	testhelper.DiffString(t, "original", "icon", meta["icon"], "star")
+	_, cost, _ := st.CheapestPath("A", "B")
+	testhelper.DiffInt(t, "original", "cost", cost, 1)
+
+	f := fsm.New(st, nil)
+	if err := f.ChangeState("A"); err != nil {
+		t.Error("original: unexpected error:", err)
+	}
+	cf := fsm.New(c, nil)
+	err = cf.ChangeState("A")
+	testhelper.CheckExpErrWithID(t, "copy", err,
+		testhelper.MkExpErr("copy guard"))
+}
+
+func TestSummary(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		transitions    []fsm.STPair
+		expUnreachable []string
+		expSummary     string
+	}{
+		{
+			ID: testhelper.MkID("one of each"),
+			transitions: []fsm.STPair{
+				{fsm.InitState, "done"},
+			},
+			expUnreachable: []string{},
+			expSummary:     "testStateTrans: 2 states, 1 transition, 1 terminal",
+		},
+		{
+			ID: testhelper.MkID("lifecycle"),
+			transitions: []fsm.STPair{
+				{fsm.InitState, "active"},
+				{"active", "suspended"},
+				{"suspended", "active"},
+				{"active", "closed"},
+				{"suspended", "closed"},
+				{"active", "deleted"},
+			},
+			expUnreachable: []string{},
+			expSummary: "testStateTrans:" +
+				" 5 states, 6 transitions, 2 terminals",
+		},
+		{
+			ID: testhelper.MkID("unreachable"),
+			transitions: []fsm.STPair{
+				{fsm.InitState, "active"},
+				{"legacy", "active"},
+				{"older", "legacy"},
+			},
+			expUnreachable: []string{"legacy", "older"},
+			expSummary: "testStateTrans:" +
+				" 4 states, 3 transitions, 1 terminal, 2 unreachable",
+		},
+	}
+
+	for _, tc := range testCases {
+		st, err := fsm.NewStateTransAuto("testStateTrans", tc.transitions...)
+		if err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+		testhelper.DiffStringSlice(t, tc.IDStr(), "unreachable states",
+			st.UnreachableStates(), tc.expUnreachable)
+		testhelper.DiffString(t, tc.IDStr(), "summary",
+			st.Summary(), tc.expSummary)
+	}
+}
+
+func TestReachabilityClosure(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "ReadyToReview"},
+		fsm.STPair{"ReadyToReview", "UnderReview"},
+		fsm.STPair{"UnderReview", "ReadyToFix"},
+		fsm.STPair{"ReadyToFix", "ReadyToReview"},
+		fsm.STPair{"UnderReview", "Approved"},
+		fsm.STPair{"Approved", "Approved"},
+		fsm.STPair{"UnderReview", "Rejected"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	cycle := []string{
+		"Approved", "ReadyToFix", "ReadyToReview", "Rejected", "UnderReview",
+	}
+	if err := testhelper.DiffVals(st.ReachabilityClosure(),
+		map[string][]string{
+			fsm.InitState:   cycle,
+			"ReadyToReview": cycle,
+			"UnderReview":   cycle,
+			"ReadyToFix":    cycle,
+			"Approved":      {"Approved"},
+			"Rejected":      {},
+		}); err != nil {
+		t.Errorf("unexpected closure: %s", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "B"},
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	ro := st.ReadOnly()
+	if _, ok := ro.(*fsm.StateTrans); ok {
+		t.Error("the read-only view can be converted back to a StateTrans")
+	}
+	testhelper.DiffString(t, "read-only", "name", ro.Name(), "testStateTrans")
+	testhelper.DiffStringSlice(t, "read-only", "states",
+		ro.States(), []string{"A", "B", fsm.InitState})
+	testhelper.DiffStringSlice(t, "read-only", "next states of init",
+		ro.NextStatesOf(fsm.InitState), []string{"A", "B"})
+	testhelper.DiffStringSlice(t, "read-only", "next states of B",
+		ro.NextStatesOf("B"), []string{})
+	testhelper.DiffStringSlice(t, "read-only", "next states of nonesuch",
+		ro.NextStatesOf("nonesuch"), nil)
+	testhelper.DiffString(t, "read-only", "summary",
+		ro.Summary(), st.Summary())
+
+	if err = st.SetStateGroup("A", "first"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	group, _ := ro.StateGroup("A")
+	testhelper.DiffString(t, "changed", "group", group, "first")
+}
+
+func TestStatesCommittedTo(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "UnderReview"},
+		fsm.STPair{"UnderReview", "ReadyToFix"},
+		fsm.STPair{"ReadyToFix", "UnderReview"},
+		fsm.STPair{"UnderReview", "Rejected"},
+		fsm.STPair{"UnderReview", "Approved"},
+		fsm.STPair{"Approved", "Testing"},
+		fsm.STPair{"Testing", "Approved"},
+		fsm.STPair{"Testing", "Released"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		terminal     string
+		expCommitted []string
+	}{
+		{
+			ID:           testhelper.MkID("released"),
+			terminal:     "Released",
+			expCommitted: []string{"Approved", "Released", "Testing"},
+		},
+		{
+			ID:           testhelper.MkID("rejected"),
+			terminal:     "Rejected",
+			expCommitted: []string{"Rejected"},
+		},
+		{
+			ID:       testhelper.MkID("not terminal"),
+			terminal: "Testing",
+		},
+		{
+			ID:       testhelper.MkID("unknown state"),
+			terminal: "nonesuch",
+		},
+	}
+
+	for _, tc := range testCases {
+		testhelper.DiffStringSlice(t, tc.IDStr(), "committed states",
+			st.StatesCommittedTo(tc.terminal), tc.expCommitted)
+	}
+}
+
+func TestCheckAdditions(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "A"},
+		fsm.STPair{"A", "B"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	fp := st.Fingerprint()
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		additions []fsm.STPair
+	}{
+		{
+			ID: testhelper.MkID("good"),
+			additions: []fsm.STPair{
+				{"B", "C"},
+				{"C", "D"},
+				{"D", "A"},
+			},
+		},
+		{
+			ID: testhelper.MkID("bad - out of order"),
+			additions: []fsm.STPair{
+				{"D", "A"},
+				{"B", "C"},
+				{"C", "D"},
+				{"E", "A"},
+			},
+			ExpErr: testhelper.MkExpErr(
+				"testStateTrans: state: 'D' does not exist." +
+					" Add('D', 'A') failed\n" +
+					"testStateTrans: state: 'E' does not exist." +
+					" Add('E', 'A') failed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		err := st.CheckAdditions(tc.additions...)
+		testhelper.CheckExpErr(t, err, tc)
+		testhelper.DiffString(t, tc.IDStr(), "fingerprint",
+			st.Fingerprint(), fp)
+		testhelper.DiffInt(t, tc.IDStr(), "state count", st.StateCount(), 3)
+	}
+}
+
+func TestAssertExclusiveTerminals(t *testing.T) {
+	st, err := fsm.NewStateTrans("testStateTrans",
+		fsm.STPair{fsm.InitState, "Testing"},
+		fsm.STPair{fsm.InitState, "Withdrawn"},
+		fsm.STPair{"Testing", "Released"},
+		fsm.STPair{"Testing", "Failed"},
+		fsm.STPair{"Failed", "Rejected"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	for _, stp := range []fsm.STPair{
+		{From: "Withdrawn", To: fsm.InitState},
+		{From: "Rejected", To: "Failed"},
+	} {
+		if err := st.AddReopen(stp.From, stp.To); err != nil {
+			t.Fatal("couldn't setup the test:", err)
+		}
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		group []string
+	}{
+		{
+			ID:    testhelper.MkID("no reopening"),
+			group: []string{"Released", "Rejected"},
+		},
+		{
+			ID:    testhelper.MkID("reopening"),
+			group: []string{"Released", "Rejected", "Withdrawn"},
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "init" can reach more than one of` +
+					" the exclusive terminal states:" +
+					" Rejected, Released, Withdrawn\n" +
+					`testStateTrans: state: "Withdrawn" can reach more than` +
+					" one of the exclusive terminal states:" +
+					" Rejected, Released, Withdrawn"),
+		},
+		{
+			ID:    testhelper.MkID("not terminal"),
+			group: []string{"Released", "Failed"},
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "Failed" is not a terminal state`),
+		},
+		{
+			ID:    testhelper.MkID("unknown state"),
+			group: []string{"nonesuch"},
+			ExpErr: testhelper.MkExpErr(
+				`testStateTrans: state: "nonesuch" does not exist`),
+		},
+	}
+
+	for _, tc := range testCases {
+		err := st.AssertExclusiveTerminals(tc.group...)
+		testhelper.CheckExpErr(t, err, tc)
+	}
+}
+
+func TestPruned(t *testing.T) {
+	st, err := fsm.NewStateTransAuto("testStateTrans",
+		fsm.STPair{fsm.InitState, "active"},
+		fsm.STPair{"active", "closed"},
+		fsm.STPair{"legacy", "active"},
+		fsm.STPair{"older", "legacy"})
+	if err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	if err = st.AddAlias("legacy", "old"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	if err = st.AddReopen("closed", "legacy"); err != nil {
+		t.Fatal("couldn't setup the test:", err)
+	}
+	summary := st.Summary()
+
+	pruned := st.Pruned()
+	testhelper.DiffStringSlice(t, "pruned", "states",
+		pruned.States(), []string{"active", "closed", fsm.InitState})
+	testhelper.DiffStringSlice(t, "pruned", "unreachable states",
+		pruned.UnreachableStates(), []string{})
+	testhelper.DiffBool(t, "pruned", "has alias", pruned.HasState("old"), false)
+	testhelper.DiffString(t, "pruned", "summary", pruned.Summary(),
+		"testStateTrans: 3 states, 2 transitions, 1 terminal")
+
+	f := fsm.New(pruned, nil)
+	for _, s := range []string{"active", "closed"} {
+		if err := f.ChangeState(s); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	err = f.Reopen("legacy")
+	testhelper.CheckExpErrWithID(t, "pruned reopen", err,
+		testhelper.MkExpErr(`"legacy"`))
+
+	testhelper.DiffString(t, "original", "summary", st.Summary(), summary)
+	testhelper.DiffBool(t, "original", "has alias", st.HasState("old"), true)
+}
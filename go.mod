@@ -2,6 +2,9 @@ module github.com/nickwells/fsm.mod
 
 go 1.18
 
-require github.com/nickwells/testhelper.mod/v2 v2.3.0
+require (
+	github.com/nickwells/testhelper.mod/v2 v2.3.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect